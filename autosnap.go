@@ -0,0 +1,106 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+// SnapshotPolicy describes when a new snapshot is due. A snapshot is due once
+// either threshold is reached since the previous snapshot. Zero thresholds
+// are disabled.
+type SnapshotPolicy struct {
+	// Number of inserts and deletes
+	Mutations int64
+	// Bytes of item data inserted and deleted
+	Bytes int64
+}
+
+// SnapshotHook is invoked with every snapshot created by an AutoSnapshotter.
+// The hook should Open() the snapshot if it retains it after returning.
+type SnapshotHook func(*Snapshot)
+
+// AutoSnapshotter creates snapshots as per a SnapshotPolicy and notifies the
+// registered hooks, so that persistence or replication layers do not have to
+// track mutation counts themselves.
+//
+// Since NewSnapshot() cannot run concurrently with writers, MaybeSnapshot()
+// should be called from a point where no writer is active, such as after each
+// batch of a writer loop.
+type AutoSnapshotter struct {
+	db     *Nitro
+	policy SnapshotPolicy
+	hooks  []SnapshotHook
+	snap   *Snapshot
+}
+
+// NewAutoSnapshotter creates an AutoSnapshotter for the Nitro instance
+func (m *Nitro) NewAutoSnapshotter(p SnapshotPolicy) *AutoSnapshotter {
+	return &AutoSnapshotter{db: m, policy: p}
+}
+
+// AddHook registers a hook called for every automatic snapshot
+func (a *AutoSnapshotter) AddHook(fn SnapshotHook) {
+	a.hooks = append(a.hooks, fn)
+}
+
+// Pending returns the changes accumulated by all writers since the previous
+// snapshot
+func (a *AutoSnapshotter) Pending() SnapshotDelta {
+	var delta SnapshotDelta
+	for w := a.db.wlist; w != nil; w = w.next {
+		delta.merge(&w.delta)
+	}
+	return delta
+}
+
+// Due returns true if the policy requires a new snapshot
+func (a *AutoSnapshotter) Due() bool {
+	d := a.Pending()
+	if a.policy.Mutations > 0 && d.ItemsAdded+d.ItemsRemoved >= a.policy.Mutations {
+		return true
+	}
+
+	return a.policy.Bytes > 0 && d.BytesAdded+d.BytesRemoved >= a.policy.Bytes
+}
+
+// MaybeSnapshot creates a snapshot and notifies the hooks if one is due.
+// It returns nil if no snapshot was created.
+// The previous automatic snapshot is closed after the hooks are notified.
+func (a *AutoSnapshotter) MaybeSnapshot() (*Snapshot, error) {
+	if !a.Due() {
+		return nil, nil
+	}
+
+	snap, err := a.db.NewSnapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fn := range a.hooks {
+		fn(snap)
+	}
+
+	if a.snap != nil {
+		a.snap.Close()
+	}
+	a.snap = snap
+
+	return snap, nil
+}
+
+// Current returns the latest automatic snapshot
+func (a *AutoSnapshotter) Current() *Snapshot {
+	return a.snap
+}
+
+// Close releases the latest automatic snapshot
+func (a *AutoSnapshotter) Close() {
+	if a.snap != nil {
+		a.snap.Close()
+		a.snap = nil
+	}
+}
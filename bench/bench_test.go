@@ -0,0 +1,93 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/t3rm1n4l/nitro"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"math/rand"
+	"testing"
+)
+
+func TestStandardWorkloads(t *testing.T) {
+	cfg := Config{RecordCount: 10000, OperationCount: 20000, Threads: 4, ValueSize: 32, Seed: 1}
+	store := NewNitroStore(nitro.DefaultConfig())
+	defer store.Close()
+
+	res, err := Load(store, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Operations != cfg.RecordCount || res.Ops[OpInsert].Errors != 0 {
+		t.Errorf("Unexpected load result %+v", res)
+	}
+
+	c := store.NewClient()
+	if v, _ := c.Read(Key(9999)); len(v) != cfg.ValueSize {
+		t.Errorf("Expected loaded record, got %q", v)
+	}
+	if n, _ := c.Scan(Key(9990), 100); n != 10 {
+		t.Errorf("Expected 10 scanned items, got %d", n)
+	}
+	c.Close()
+
+	results := []Result{res}
+	for _, wl := range StandardWorkloads() {
+		res, err := Run(store, wl, cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", wl.Name, err)
+		}
+
+		if res.Operations != cfg.OperationCount {
+			t.Errorf("%s: expected %d operations, got %d", wl.Name, cfg.OperationCount, res.Operations)
+		}
+		results = append(results, res)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteResults(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []Result
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded) != len(results) {
+		t.Errorf("Unable to decode results: %v", err)
+	}
+
+	if _, err := Run(store, Workload{Name: "bad", ReadProportion: 0.5}, cfg); !errors.Is(err, nerrors.ErrInvalidConfig) {
+		t.Errorf("Expected invalid workload error, got %v", err)
+	}
+}
+
+func TestKeyChooser(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	freq := make(map[uint64]int)
+	kc := newKeyChooser(r, Zipfian, 1000)
+	for i := 0; i < 10000; i++ {
+		n := kc.next(1000)
+		if n >= 1000 {
+			t.Fatalf("Record %d out of range", n)
+		}
+		freq[n]++
+	}
+
+	if hot := freq[scramble(0)%1000]; hot < 1000 {
+		t.Errorf("Expected a hot key, got %d accesses", hot)
+	}
+
+	kc = newKeyChooser(r, Latest, 1000)
+	if n := kc.next(2000); n < 1000 {
+		t.Errorf("Expected a recent record, got %d", n)
+	}
+}
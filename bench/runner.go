@@ -0,0 +1,246 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package bench
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultThreads   = 4
+	defaultValueSize = 100
+)
+
+// Config configures a benchmark run
+type Config struct {
+	// Number of records inserted by the load phase
+	RecordCount uint64
+
+	// Number of operations performed by a workload run
+	OperationCount uint64
+
+	Threads   int
+	ValueSize int
+
+	// Seed makes the generated operations reproducible
+	Seed int64
+}
+
+func (cfg *Config) threads() int {
+	if cfg.Threads <= 0 {
+		return defaultThreads
+	}
+	return cfg.Threads
+}
+
+func (cfg *Config) valueSize() int {
+	if cfg.ValueSize <= 0 {
+		return defaultValueSize
+	}
+	return cfg.ValueSize
+}
+
+// OpStats describes the latency of one type of operation in nanoseconds
+type OpStats struct {
+	Count  uint64 `json:"count"`
+	Errors uint64 `json:"errors"`
+	Avg    int64  `json:"avg_ns"`
+	P50    int64  `json:"p50_ns"`
+	P95    int64  `json:"p95_ns"`
+	P99    int64  `json:"p99_ns"`
+	Max    int64  `json:"max_ns"`
+}
+
+// Result is the outcome of a benchmark phase
+type Result struct {
+	Workload   string             `json:"workload"`
+	Threads    int                `json:"threads"`
+	Records    uint64             `json:"records"`
+	Operations uint64             `json:"operations"`
+	Duration   time.Duration      `json:"duration_ns"`
+	Throughput float64            `json:"ops_per_sec"`
+	Ops        map[string]OpStats `json:"ops"`
+}
+
+// WriteResults writes the results as a JSON array
+func WriteResults(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+type recorder struct {
+	samples map[string][]int64
+	errors  map[string]uint64
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		samples: make(map[string][]int64),
+		errors:  make(map[string]uint64),
+	}
+}
+
+func (rc *recorder) record(op string, t0 time.Time, err error) {
+	rc.samples[op] = append(rc.samples[op], int64(time.Since(t0)))
+	if err != nil {
+		rc.errors[op]++
+	}
+}
+
+func (rc *recorder) merge(o *recorder) {
+	for op, s := range o.samples {
+		rc.samples[op] = append(rc.samples[op], s...)
+	}
+
+	for op, n := range o.errors {
+		rc.errors[op] += n
+	}
+}
+
+func (rc *recorder) result(name string, cfg *Config, records uint64, d time.Duration) Result {
+	res := Result{
+		Workload: name,
+		Threads:  cfg.threads(),
+		Records:  records,
+		Duration: d,
+		Ops:      make(map[string]OpStats),
+	}
+
+	for op, s := range rc.samples {
+		sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+		var sum int64
+		for _, v := range s {
+			sum += v
+		}
+
+		pct := func(p float64) int64 {
+			return s[int(p*float64(len(s)-1))]
+		}
+
+		res.Operations += uint64(len(s))
+		res.Ops[op] = OpStats{
+			Count:  uint64(len(s)),
+			Errors: rc.errors[op],
+			Avg:    sum / int64(len(s)),
+			P50:    pct(0.5),
+			P95:    pct(0.95),
+			P99:    pct(0.99),
+			Max:    s[len(s)-1],
+		}
+	}
+
+	if d > 0 {
+		res.Throughput = float64(res.Operations) / d.Seconds()
+	}
+
+	return res
+}
+
+// run executes fn concurrently on every thread and merges the recorded latencies
+func run(store Store, cfg *Config, fn func(thr int, c Client, r *rand.Rand, rc *recorder)) (*recorder, time.Duration) {
+	var wg sync.WaitGroup
+	threads := cfg.threads()
+	recorders := make([]*recorder, threads)
+
+	t0 := time.Now()
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		recorders[i] = newRecorder()
+		go func(i int) {
+			defer wg.Done()
+			c := store.NewClient()
+			defer c.Close()
+			fn(i, c, rand.New(rand.NewSource(cfg.Seed+int64(i))), recorders[i])
+		}(i)
+	}
+	wg.Wait()
+	d := time.Since(t0)
+
+	for _, rc := range recorders[1:] {
+		recorders[0].merge(rc)
+	}
+
+	return recorders[0], d
+}
+
+// Load inserts cfg.RecordCount records into the store
+func Load(store Store, cfg Config) (Result, error) {
+	threads := uint64(cfg.threads())
+	rc, d := run(store, &cfg, func(thr int, c Client, r *rand.Rand, rc *recorder) {
+		val := make([]byte, cfg.valueSize())
+		for n := uint64(thr); n < cfg.RecordCount; n += threads {
+			t0 := time.Now()
+			rc.record(OpInsert, t0, c.Insert(Key(n), fillValue(r, val)))
+		}
+	})
+
+	if err := store.Sync(); err != nil {
+		return Result{}, err
+	}
+
+	return rc.result("load", &cfg, cfg.RecordCount, d), nil
+}
+
+// Run performs cfg.OperationCount operations of the workload on a store which
+// was loaded using Load()
+func Run(store Store, wl Workload, cfg Config) (Result, error) {
+	if err := wl.validate(); err != nil {
+		return Result{}, err
+	}
+
+	// Records inserted by the workload are numbered after the loaded ones
+	inserted := cfg.RecordCount
+	threads := uint64(cfg.threads())
+	rc, d := run(store, &cfg, func(thr int, c Client, r *rand.Rand, rc *recorder) {
+		var err error
+		val := make([]byte, cfg.valueSize())
+		kc := newKeyChooser(r, wl.Distribution, cfg.RecordCount)
+		ops := cfg.OperationCount / threads
+		if uint64(thr) < cfg.OperationCount%threads {
+			ops++
+		}
+
+		for i := uint64(0); i < ops; i++ {
+			op := wl.nextOp(r)
+			t0 := time.Now()
+			switch op {
+			case OpInsert:
+				n := atomic.AddUint64(&inserted, 1) - 1
+				err = c.Insert(Key(n), fillValue(r, val))
+			case OpRead:
+				_, err = c.Read(Key(kc.next(atomic.LoadUint64(&inserted))))
+			case OpUpdate:
+				err = c.Update(Key(kc.next(atomic.LoadUint64(&inserted))), fillValue(r, val))
+			case OpScan:
+				key := Key(kc.next(atomic.LoadUint64(&inserted)))
+				_, err = c.Scan(key, 1+r.Intn(wl.MaxScanLength))
+			case OpRMW:
+				key := Key(kc.next(atomic.LoadUint64(&inserted)))
+				if _, err = c.Read(key); err == nil {
+					err = c.Update(key, fillValue(r, val))
+				}
+			}
+			rc.record(op, t0, err)
+		}
+	})
+
+	if err := store.Sync(); err != nil {
+		return Result{}, err
+	}
+
+	return rc.result(wl.Name, &cfg, inserted, d), nil
+}
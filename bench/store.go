@@ -0,0 +1,133 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package bench implements YCSB style workload drivers which can be run
+// against any key-value store implementing the Store interface. Results are
+// reported in a machine readable form for regression tracking.
+//
+// A Nitro implementation of Store is provided by NewNitroStore().
+package bench
+
+import (
+	"github.com/t3rm1n4l/nitro"
+	"sync"
+)
+
+// Store is the key-value store under benchmark
+type Store interface {
+	// NewClient returns a handle used by a single benchmark thread
+	NewClient() Client
+
+	// Sync makes the previous writes visible to scans. It is called
+	// between benchmark phases while no client is active.
+	Sync() error
+
+	Close()
+}
+
+// Client performs operations on behalf of a single benchmark thread
+type Client interface {
+	Insert(key, value []byte) error
+	Update(key, value []byte) error
+
+	// Read returns a nil value if the key does not exist
+	Read(key []byte) ([]byte, error)
+
+	// Scan reads upto n items starting from key and returns the count read
+	Scan(key []byte, n int) (int, error)
+
+	Close()
+}
+
+type nitroStore struct {
+	db *nitro.Nitro
+
+	sync.RWMutex
+	snap *nitro.Snapshot
+}
+
+// NewNitroStore creates a Store backed by a Nitro instance. Items are stored
+// using nitro.KVToBytes(). Scans are served from the snapshot created by the
+// latest Sync().
+func NewNitroStore(cfg nitro.Config) Store {
+	cfg.SetKeyComparator(nitro.CompareKV)
+	return &nitroStore{db: nitro.NewWithConfig(cfg)}
+}
+
+func (s *nitroStore) NewClient() Client {
+	return &nitroClient{store: s, w: s.db.NewWriter()}
+}
+
+func (s *nitroStore) Sync() error {
+	snap, err := s.db.NewSnapshot()
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	if s.snap != nil {
+		s.snap.Close()
+	}
+	s.snap = snap
+	s.Unlock()
+	return nil
+}
+
+func (s *nitroStore) Close() {
+	if s.snap != nil {
+		s.snap.Close()
+	}
+	s.db.Close()
+}
+
+type nitroClient struct {
+	store *nitroStore
+	w     *nitro.Writer
+}
+
+func (c *nitroClient) Insert(key, value []byte) error {
+	c.w.Put(nitro.KVToBytes(key, value))
+	return nil
+}
+
+func (c *nitroClient) Update(key, value []byte) error {
+	c.w.Delete(nitro.KVToBytes(key, nil))
+	return c.Insert(key, value)
+}
+
+func (c *nitroClient) Read(key []byte) ([]byte, error) {
+	n := c.w.GetNode(nitro.KVToBytes(key, nil))
+	if n == nil {
+		return nil, nil
+	}
+
+	_, v := nitro.KVFromBytes((*nitro.Item)(n.Item()).Bytes())
+	return v, nil
+}
+
+func (c *nitroClient) Scan(key []byte, n int) (int, error) {
+	c.store.RLock()
+	defer c.store.RUnlock()
+	if c.store.snap == nil {
+		return 0, nil
+	}
+
+	itr := c.store.snap.NewIterator()
+	defer itr.Close()
+
+	count := 0
+	for itr.Seek(nitro.KVToBytes(key, nil)); itr.Valid() && count < n; itr.Next() {
+		count++
+	}
+
+	return count, nil
+}
+
+// Nitro writers do not need to be closed
+func (c *nitroClient) Close() {}
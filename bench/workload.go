@@ -0,0 +1,171 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package bench
+
+import (
+	"fmt"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"math/rand"
+)
+
+// Distribution selects the keys accessed by a workload
+type Distribution int
+
+const (
+	// Uniform accesses all keys with equal probability
+	Uniform Distribution = iota
+	// Zipfian concentrates accesses on a small set of hot keys
+	Zipfian
+	// Latest concentrates accesses on the most recently inserted keys
+	Latest
+)
+
+// Operation types performed by workloads
+const (
+	OpInsert = "insert"
+	OpRead   = "read"
+	OpUpdate = "update"
+	OpScan   = "scan"
+	OpRMW    = "read-modify-write"
+)
+
+// Workload describes the operation mix of a benchmark run. The proportions
+// should add up to 1.
+type Workload struct {
+	Name string
+
+	ReadProportion   float64
+	UpdateProportion float64
+	InsertProportion float64
+	ScanProportion   float64
+	RMWProportion    float64
+
+	// Maximum number of items read by a scan
+	MaxScanLength int
+
+	Distribution Distribution
+}
+
+// Standard workloads modelled after the YCSB core workloads
+var (
+	UpdateHeavy = Workload{Name: "update-heavy", ReadProportion: 0.5,
+		UpdateProportion: 0.5, Distribution: Zipfian}
+	ReadHeavy = Workload{Name: "read-heavy", ReadProportion: 0.95,
+		UpdateProportion: 0.05, Distribution: Zipfian}
+	ReadOnly = Workload{Name: "read-only", ReadProportion: 1,
+		Distribution: Zipfian}
+	ReadLatest = Workload{Name: "read-latest", ReadProportion: 0.95,
+		InsertProportion: 0.05, Distribution: Latest}
+	ScanHeavy = Workload{Name: "scan-heavy", ScanProportion: 0.95,
+		InsertProportion: 0.05, MaxScanLength: 100, Distribution: Zipfian}
+	ReadModifyWrite = Workload{Name: "read-modify-write", ReadProportion: 0.5,
+		RMWProportion: 0.5, Distribution: Zipfian}
+)
+
+// StandardWorkloads returns all the standard workloads
+func StandardWorkloads() []Workload {
+	return []Workload{UpdateHeavy, ReadHeavy, ReadOnly, ReadLatest, ScanHeavy, ReadModifyWrite}
+}
+
+func (wl *Workload) validate() error {
+	p := wl.ReadProportion + wl.UpdateProportion + wl.InsertProportion +
+		wl.ScanProportion + wl.RMWProportion
+	if p < 0.999 || p > 1.001 {
+		return nerrors.Wrap(nerrors.ErrInvalidConfig,
+			fmt.Errorf("bench: workload %s proportions add up to %.3f", wl.Name, p))
+	}
+
+	if wl.ScanProportion > 0 && wl.MaxScanLength <= 0 {
+		return nerrors.Wrap(nerrors.ErrInvalidConfig,
+			fmt.Errorf("bench: workload %s needs a scan length", wl.Name))
+	}
+
+	return nil
+}
+
+func (wl *Workload) nextOp(r *rand.Rand) string {
+	p := r.Float64()
+	for _, op := range []struct {
+		name string
+		p    float64
+	}{
+		{OpRead, wl.ReadProportion},
+		{OpUpdate, wl.UpdateProportion},
+		{OpInsert, wl.InsertProportion},
+		{OpScan, wl.ScanProportion},
+	} {
+		if p < op.p {
+			return op.name
+		}
+		p -= op.p
+	}
+
+	return OpRMW
+}
+
+// keyChooser picks record numbers out of the currently inserted records
+type keyChooser struct {
+	r    *rand.Rand
+	dist Distribution
+	zipf *rand.Zipf
+	max  uint64
+}
+
+// The zipfian exponent should be greater than 1 for math/rand, which is
+// slightly more skewed than the YCSB default of 0.99
+const zipfExponent = 1.01
+
+func newKeyChooser(r *rand.Rand, dist Distribution, records uint64) *keyChooser {
+	kc := &keyChooser{r: r, dist: dist, max: records}
+	if dist != Uniform && records > 1 {
+		kc.zipf = rand.NewZipf(r, zipfExponent, 1, records-1)
+	}
+
+	return kc
+}
+
+// next returns a record number smaller than `inserted`
+func (kc *keyChooser) next(inserted uint64) uint64 {
+	switch {
+	case inserted == 0:
+		return 0
+	case kc.zipf == nil:
+		return uint64(kc.r.Int63n(int64(inserted)))
+	case kc.dist == Latest:
+		n := kc.zipf.Uint64() % inserted
+		return inserted - 1 - n
+	}
+
+	// Hot keys are scattered across the keyspace like YCSB does
+	return scramble(kc.zipf.Uint64()) % inserted
+}
+
+// FNV-1a hash of the record number
+func scramble(n uint64) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < 8; i++ {
+		h ^= n & 0xff
+		h *= 1099511628211
+		n >>= 8
+	}
+	return h
+}
+
+// Key returns the key of the record number
+func Key(n uint64) []byte {
+	return []byte(fmt.Sprintf("user%012d", n))
+}
+
+func fillValue(r *rand.Rand, buf []byte) []byte {
+	for i := range buf {
+		buf[i] = byte('a' + r.Intn(26))
+	}
+	return buf
+}
@@ -0,0 +1,453 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// BlobStore is a minimal object store interface used to stream disk backups
+// to remote storage. Get should return an error satisfying os.IsNotExist()
+// for missing objects.
+type BlobStore interface {
+	Put(name string, r io.Reader) error
+	Get(name string) (io.ReadCloser, error)
+	List(prefix string) ([]string, error)
+}
+
+const (
+	defaultBlobPartSize = 8 * 1024 * 1024
+	defaultBlobRetries  = 3
+	defaultBlobBackoff  = 100 * time.Millisecond
+	maxBlobBackoff      = 10 * time.Second
+	blobManifest        = "manifest.json"
+	blobUploadState     = "upload.json"
+)
+
+var errIncompleteBlob = nerrors.New(nerrors.ErrCorrupt, "Incomplete blob upload")
+
+// BlobOptions configures a BlobStore backed filesystem
+type BlobOptions struct {
+	// Files are uploaded as a sequence of parts of PartSize bytes
+	PartSize int
+
+	// Failed part uploads and downloads are retried upto Retries times
+	// without restarting the file
+	Retries int
+
+	// RetryBackoff is the wait before the first retry. It is doubled after
+	// every failed retry.
+	RetryBackoff time.Duration
+}
+
+/*
+* Every file is stored as a set of objects:
+*   <name>/part-000000 ... <name>/part-NNNNNN
+*   <name>/manifest.json
+*   <name>/upload.json
+*
+* Parts are uploaded as soon as they fill up, so that a backup is streamed to
+* the store without an intermediate local copy. The manifest is uploaded when
+* the file is closed and marks the file as complete. A file being written has
+* an in progress manifest, so that readers do not mix the parts of an earlier
+* upload with the new ones.
+*
+* The upload state records the size and checksum of every stored part. When
+* the file is written again, for example by a retry of an interrupted backup,
+* parts identical to the stored ones are not uploaded again, so the upload
+* resumes from the parts already stored.
+* */
+
+type blobFileManifest struct {
+	Size     int64 `json:"size"`
+	PartSize int   `json:"part_size"`
+	Parts    int   `json:"parts"`
+
+	InProgress bool `json:"in_progress,omitempty"`
+}
+
+type blobPart struct {
+	Size int    `json:"size"`
+	CRC  uint32 `json:"crc"`
+}
+
+type blobUpload struct {
+	PartSize int        `json:"part_size"`
+	Parts    []blobPart `json:"parts"`
+}
+
+type blobFileSystem struct {
+	store BlobStore
+	opts  BlobOptions
+}
+
+// NewBlobFileSystem creates a FileSystem which stores files in the BlobStore.
+// Files are write-once: they can be either written sequentially or read.
+// It can be provided to Config.SetFileSystem() to stream disk backups to and
+// restore them from an object store.
+func NewBlobFileSystem(store BlobStore, opts BlobOptions) FileSystem {
+	if opts.PartSize <= 0 {
+		opts.PartSize = defaultBlobPartSize
+	}
+
+	if opts.Retries <= 0 {
+		opts.Retries = defaultBlobRetries
+	}
+
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = defaultBlobBackoff
+	}
+
+	return &blobFileSystem{store: store, opts: opts}
+}
+
+func blobName(name string) string {
+	return path.Clean(filepath.ToSlash(name))
+}
+
+func blobPartName(name string, part int) string {
+	return fmt.Sprintf("%s/part-%06d", name, part)
+}
+
+func (fs *blobFileSystem) retry(fn func() error) error {
+	var err error
+	backoff := fs.opts.RetryBackoff
+	for i := 0; i < fs.opts.Retries; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > maxBlobBackoff {
+				backoff = maxBlobBackoff
+			}
+		}
+
+		if err = fn(); err == nil || os.IsNotExist(err) {
+			break
+		}
+	}
+
+	return err
+}
+
+func (fs *blobFileSystem) put(name string, bs []byte) error {
+	return fs.retry(func() error {
+		return fs.store.Put(name, bytes.NewReader(bs))
+	})
+}
+
+func (fs *blobFileSystem) get(name string) ([]byte, error) {
+	var bs []byte
+	err := fs.retry(func() error {
+		r, err := fs.store.Get(name)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		bs, err = ioutil.ReadAll(r)
+		return err
+	})
+
+	return bs, err
+}
+
+func (fs *blobFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = blobName(name)
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrInvalid}
+		}
+
+		f := &blobFile{fs: fs, name: name, writable: true, part: -1}
+		f.stored = fs.storedParts(name)
+		f.upload.PartSize = fs.opts.PartSize
+
+		// Invalidate the manifest of an earlier upload before its parts
+		// are overwritten
+		bs, err := json.Marshal(blobFileManifest{InProgress: true})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := fs.put(path.Join(name, blobManifest), bs); err != nil {
+			return nil, err
+		}
+
+		return f, nil
+	}
+
+	bs, err := fs.get(path.Join(name, blobManifest))
+	if err != nil {
+		return nil, err
+	}
+
+	f := &blobFile{fs: fs, name: name, part: -1}
+	if err := json.Unmarshal(bs, &f.manifest); err != nil {
+		return nil, err
+	}
+
+	if f.manifest.InProgress {
+		return nil, errIncompleteBlob
+	}
+
+	parts, err := fs.store.List(name + "/part-")
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool)
+	for _, p := range parts {
+		found[p] = true
+	}
+
+	for i := 0; i < f.manifest.Parts; i++ {
+		if !found[blobPartName(name, i)] {
+			return nil, errIncompleteBlob
+		}
+	}
+
+	return f, nil
+}
+
+// storedParts returns the parts of an earlier upload of the file which are
+// still present in the store
+func (fs *blobFileSystem) storedParts(name string) []blobPart {
+	bs, err := fs.get(path.Join(name, blobUploadState))
+	if err != nil {
+		return nil
+	}
+
+	var upload blobUpload
+	if json.Unmarshal(bs, &upload) != nil || upload.PartSize != fs.opts.PartSize {
+		return nil
+	}
+
+	parts, err := fs.store.List(name + "/part-")
+	if err != nil {
+		return nil
+	}
+
+	found := make(map[string]bool)
+	for _, p := range parts {
+		found[p] = true
+	}
+
+	for i := range upload.Parts {
+		if !found[blobPartName(name, i)] {
+			return upload.Parts[:i]
+		}
+	}
+
+	return upload.Parts
+}
+
+// MkdirAll is a no-op since object stores do not have directories
+func (fs *blobFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return nil
+}
+
+type blobFile struct {
+	fs       *blobFileSystem
+	name     string
+	writable bool
+	manifest blobFileManifest
+	pos      int64
+	closed   bool
+
+	// Parts of an earlier upload and of the current upload
+	stored []blobPart
+	upload blobUpload
+
+	// Current part being written or read
+	part int
+	buf  []byte
+}
+
+func (f *blobFile) pathError(op string, err error) error {
+	return &os.PathError{Op: op, Path: f.name, Err: err}
+}
+
+func (f *blobFile) Write(p []byte) (int, error) {
+	if f.closed || !f.writable {
+		return 0, f.pathError("write", os.ErrInvalid)
+	}
+
+	n := 0
+	for len(p) > 0 {
+		l := f.fs.opts.PartSize - len(f.buf)
+		if l > len(p) {
+			l = len(p)
+		}
+
+		f.buf = append(f.buf, p[:l]...)
+		p = p[l:]
+		n += l
+		f.pos += int64(l)
+
+		if len(f.buf) == f.fs.opts.PartSize {
+			if err := f.flushPart(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+func (f *blobFile) flushPart() error {
+	i := f.manifest.Parts
+	part := blobPart{Size: len(f.buf), CRC: crc32.ChecksumIEEE(f.buf)}
+	if i >= len(f.stored) || f.stored[i] != part {
+		// Later stored parts may be overwritten from now on
+		if i < len(f.stored) {
+			f.stored = f.stored[:i]
+		}
+
+		if err := f.fs.put(blobPartName(f.name, i), f.buf); err != nil {
+			return err
+		}
+	}
+
+	f.upload.Parts = append(f.upload.Parts, part)
+	bs, err := json.Marshal(f.upload)
+	if err != nil {
+		return err
+	}
+
+	if err := f.fs.put(path.Join(f.name, blobUploadState), bs); err != nil {
+		return err
+	}
+
+	f.manifest.Parts++
+	f.buf = f.buf[:0]
+	return nil
+}
+
+func (f *blobFile) loadPart(part int) error {
+	if part == f.part {
+		return nil
+	}
+
+	bs, err := f.fs.get(blobPartName(f.name, part))
+	if err != nil {
+		return err
+	}
+
+	f.part = part
+	f.buf = bs
+	return nil
+}
+
+func (f *blobFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *blobFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.closed || f.writable {
+		return 0, f.pathError("read", os.ErrInvalid)
+	}
+
+	n := 0
+	partSize := int64(f.manifest.PartSize)
+	for n < len(p) && off < f.manifest.Size {
+		if err := f.loadPart(int(off / partSize)); err != nil {
+			return n, err
+		}
+
+		i := int(off % partSize)
+		if i >= len(f.buf) {
+			return n, errIncompleteBlob
+		}
+
+		l := copy(p[n:], f.buf[i:])
+		n += l
+		off += int64(l)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (f *blobFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed || f.writable {
+		return 0, f.pathError("seek", os.ErrInvalid)
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.manifest.Size
+	}
+
+	if offset < 0 {
+		return 0, f.pathError("seek", os.ErrInvalid)
+	}
+
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *blobFile) Stat() (os.FileInfo, error) {
+	size := f.manifest.Size
+	if f.writable {
+		size = f.pos
+	}
+
+	return memFileInfo{
+		name:    path.Base(f.name),
+		size:    size,
+		modTime: time.Now(),
+	}, nil
+}
+
+func (f *blobFile) Close() error {
+	if f.closed {
+		return f.pathError("close", os.ErrClosed)
+	}
+	f.closed = true
+
+	if !f.writable {
+		f.buf = nil
+		return nil
+	}
+
+	if len(f.buf) > 0 {
+		if err := f.flushPart(); err != nil {
+			return err
+		}
+	}
+
+	f.manifest.Size = f.pos
+	f.manifest.PartSize = f.fs.opts.PartSize
+	bs, err := json.Marshal(f.manifest)
+	if err != nil {
+		return err
+	}
+
+	return f.fs.put(path.Join(f.name, blobManifest), bs)
+}
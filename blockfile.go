@@ -0,0 +1,300 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"io"
+	"os"
+	"sort"
+)
+
+/*
+* Block file format:
+* Items are grouped into blocks of upto BlockFileBlockSize bytes. An item never
+* spans blocks. Every block is described by an entry in the block index written
+* after the last block, followed by a fixed size footer.
+*
+* +---------+---------+-----+-------------+--------+
+* | block 0 | block 1 | ... | block index | footer |
+* +---------+---------+-----+-------------+--------+
+*
+* block       : [2 byte len][item_bytes][item_meta] ...
+* index entry : [8 byte offset][4 byte length][2 byte len][first item_bytes]
+* footer      : [8 byte index offset][4 byte block count][4 byte magic]
+*
+* When items are written in sorted order, the index can be binary searched using
+* the first item of every block to find the block which may contain an item.
+* */
+
+// BlockFileBlockSize is the target size of a block in block backup files
+var BlockFileBlockSize = 64 * 1024
+
+const (
+	blockFileMagic      = 0x4e425846 // NBXF
+	blockFileFooterSize = 16
+)
+
+var errBadBlockFile = nerrors.New(nerrors.ErrCorrupt, "Invalid block backup file")
+
+type blockIndexEntry struct {
+	offset int64
+	length uint32
+	first  []byte
+}
+
+type blockFileWriter struct {
+	db     *Nitro
+	fd     File
+	w      *bufio.Writer
+	buf    []byte
+	block  bytes.Buffer
+	first  []byte
+	offset int64
+	index  []blockIndexEntry
+}
+
+func (f *blockFileWriter) Open(path string) error {
+	var err error
+	f.fd, err = f.db.openFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err == nil {
+		f.buf = make([]byte, encodeBufSize)
+		f.w = bufio.NewWriterSize(f.fd, DiskBlockSize)
+	}
+	return err
+}
+
+func (f *blockFileWriter) WriteItem(itm *Item) error {
+	sz := int(f.db.encodedSize(itm))
+	if f.block.Len() > 0 && f.block.Len()+sz > BlockFileBlockSize {
+		if err := f.flushBlock(); err != nil {
+			return err
+		}
+	}
+
+	if f.block.Len() == 0 {
+		f.first = append(f.first[:0], itm.Bytes()...)
+	}
+
+	return f.db.EncodeItem(itm, f.buf, &f.block)
+}
+
+func (f *blockFileWriter) flushBlock() error {
+	if _, err := f.w.Write(f.block.Bytes()); err != nil {
+		return err
+	}
+
+	f.index = append(f.index, blockIndexEntry{
+		offset: f.offset,
+		length: uint32(f.block.Len()),
+		first:  append([]byte(nil), f.first...),
+	})
+
+	f.offset += int64(f.block.Len())
+	f.block.Reset()
+	return nil
+}
+
+func (f *blockFileWriter) Close() error {
+	err := f.writeIndex()
+	if err == nil {
+		err = f.w.Flush()
+	}
+
+	if cerr := f.fd.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+func (f *blockFileWriter) writeIndex() error {
+	if f.block.Len() > 0 {
+		if err := f.flushBlock(); err != nil {
+			return err
+		}
+	}
+
+	var hdr [14]byte
+	for _, e := range f.index {
+		binary.BigEndian.PutUint64(hdr[0:8], uint64(e.offset))
+		binary.BigEndian.PutUint32(hdr[8:12], e.length)
+		binary.BigEndian.PutUint16(hdr[12:14], uint16(len(e.first)))
+		if _, err := f.w.Write(hdr[:]); err != nil {
+			return err
+		}
+		if _, err := f.w.Write(e.first); err != nil {
+			return err
+		}
+	}
+
+	var footer [blockFileFooterSize]byte
+	binary.BigEndian.PutUint64(footer[0:8], uint64(f.offset))
+	binary.BigEndian.PutUint32(footer[8:12], uint32(len(f.index)))
+	binary.BigEndian.PutUint32(footer[12:16], blockFileMagic)
+	_, err := f.w.Write(footer[:])
+	return err
+}
+
+type blockFileReader struct {
+	db      *Nitro
+	fd      File
+	r       *bufio.Reader
+	buf     []byte
+	index   []blockIndexEntry
+	end     int64
+	pos     int64
+	pending *Item
+}
+
+func (f *blockFileReader) Open(path string) error {
+	var err error
+	if f.fd, err = f.db.openFile(path, os.O_RDONLY, 0); err != nil {
+		return err
+	}
+
+	f.buf = make([]byte, encodeBufSize)
+	if err = f.readIndex(); err != nil {
+		f.fd.Close()
+		return err
+	}
+
+	f.r = bufio.NewReaderSize(f.fd, DiskBlockSize)
+	return nil
+}
+
+func (f *blockFileReader) readIndex() error {
+	var footer [blockFileFooterSize]byte
+
+	fi, err := f.fd.Stat()
+	if err != nil {
+		return err
+	}
+
+	if fi.Size() < blockFileFooterSize {
+		return errBadBlockFile
+	}
+
+	if _, err := f.fd.ReadAt(footer[:], fi.Size()-blockFileFooterSize); err != nil {
+		return err
+	}
+
+	if binary.BigEndian.Uint32(footer[12:16]) != blockFileMagic {
+		return errBadBlockFile
+	}
+
+	f.end = int64(binary.BigEndian.Uint64(footer[0:8]))
+	count := int(binary.BigEndian.Uint32(footer[8:12]))
+	idxLen := fi.Size() - blockFileFooterSize - f.end
+	if idxLen < 0 {
+		return errBadBlockFile
+	}
+
+	idx := make([]byte, idxLen)
+	if _, err := f.fd.ReadAt(idx, f.end); err != nil {
+		return err
+	}
+
+	f.index = make([]blockIndexEntry, count)
+	for i := range f.index {
+		if len(idx) < 14 {
+			return errBadBlockFile
+		}
+
+		e := &f.index[i]
+		e.offset = int64(binary.BigEndian.Uint64(idx[0:8]))
+		e.length = binary.BigEndian.Uint32(idx[8:12])
+		l := int(binary.BigEndian.Uint16(idx[12:14]))
+		if len(idx) < 14+l {
+			return errBadBlockFile
+		}
+		e.first = idx[14 : 14+l]
+		idx = idx[14+l:]
+	}
+
+	return nil
+}
+
+func (f *blockFileReader) ReadItem() (*Item, error) {
+	if itm := f.pending; itm != nil {
+		f.pending = nil
+		return itm, nil
+	}
+
+	if f.pos >= f.end {
+		return nil, nil
+	}
+
+	itm, err := f.db.DecodeItem(f.buf, f.r)
+	if err != nil {
+		return nil, err
+	}
+
+	if itm == nil {
+		return nil, errBadBlockFile
+	}
+
+	f.pos += f.db.encodedSize(itm)
+	return itm, nil
+}
+
+// Seek moves the reader to the block which may contain the item and skips
+// the smaller items from the block
+func (f *blockFileReader) Seek(bs []byte) error {
+	if f.pending != nil {
+		f.db.freeItem(f.pending)
+		f.pending = nil
+	}
+
+	// Start from the block before the first block with first item >= bs.
+	// It may hold smaller items as well as items equal to bs, which can
+	// span blocks in multiset mode.
+	i := sort.Search(len(f.index), func(i int) bool {
+		return f.db.keyCmp(f.index[i].first, bs) >= 0
+	}) - 1
+
+	if i < 0 {
+		i = 0
+	}
+
+	f.pos = f.end
+	if i < len(f.index) {
+		f.pos = f.index[i].offset
+	}
+
+	if _, err := f.fd.Seek(f.pos, io.SeekStart); err != nil {
+		return err
+	}
+	f.r.Reset(f.fd)
+
+	for {
+		itm, err := f.ReadItem()
+		if err != nil || itm == nil {
+			return err
+		}
+
+		if f.db.keyCmp(itm.Bytes(), bs) >= 0 {
+			f.pending = itm
+			return nil
+		}
+		f.db.freeItem(itm)
+	}
+}
+
+func (f *blockFileReader) Close() error {
+	if f.pending != nil {
+		f.db.freeItem(f.pending)
+		f.pending = nil
+	}
+	return f.fd.Close()
+}
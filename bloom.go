@@ -0,0 +1,138 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"sync/atomic"
+)
+
+const defaultBloomHashes = 7
+
+// BloomFilter configures a store level bloom filter over the inserted keys
+type BloomFilter struct {
+	// Bits is the size of the filter. About 10 bits per expected key give
+	// a false positive rate of 1%.
+	Bits int
+
+	// Hashes is the number of bit positions set per key (default 7)
+	Hashes int
+
+	// Key optionally extracts the key from item bytes. It should agree with
+	// the key comparator, for example, returning the key of items encoded
+	// using KVToBytes().
+	Key func([]byte) []byte
+}
+
+// UseBloomFilter enables a bloom filter which lets point lookups for absent
+// keys return without a skiplist search. Keys are never removed from the
+// filter, so deleted keys only add to the false positive rate until the
+// instance is restored from a backup.
+func (cfg *Config) UseBloomFilter(f BloomFilter) {
+	cfg.bloomFilter = f
+}
+
+// BloomStats describes the effectiveness of the bloom filter
+type BloomStats struct {
+	Lookups uint64
+	// Lookups which skipped the skiplist search
+	Negatives uint64
+}
+
+type bloom struct {
+	bits    []uint64
+	nbits   uint64
+	hashes  int
+	keyFn   func([]byte) []byte
+	lookups uint64
+	negs    uint64
+}
+
+func newBloom(f BloomFilter) *bloom {
+	b := &bloom{
+		bits:   make([]uint64, (f.Bits+63)/64),
+		hashes: f.Hashes,
+		keyFn:  f.Key,
+	}
+
+	b.nbits = uint64(len(b.bits) * 64)
+	if b.hashes <= 0 {
+		b.hashes = defaultBloomHashes
+	}
+
+	return b
+}
+
+// hash returns two 32 bit hashes of the key using 64 bit FNV-1a
+func (b *bloom) hash(bs []byte) (uint32, uint32) {
+	if b.keyFn != nil {
+		bs = b.keyFn(bs)
+	}
+
+	h := uint64(14695981039346656037)
+	for _, c := range bs {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+
+	return uint32(h), uint32(h>>32) | 1
+}
+
+func (b *bloom) add(bs []byte) {
+	h1, h2 := b.hash(bs)
+	for i := 0; i < b.hashes; i++ {
+		pos := uint64(h1+uint32(i)*h2) % b.nbits
+		addr, mask := &b.bits[pos/64], uint64(1)<<(pos%64)
+		for {
+			old := atomic.LoadUint64(addr)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(addr, old, old|mask) {
+				break
+			}
+		}
+	}
+}
+
+func (b *bloom) mayContain(bs []byte) bool {
+	atomic.AddUint64(&b.lookups, 1)
+	h1, h2 := b.hash(bs)
+	for i := 0; i < b.hashes; i++ {
+		pos := uint64(h1+uint32(i)*h2) % b.nbits
+		if atomic.LoadUint64(&b.bits[pos/64])&(uint64(1)<<(pos%64)) == 0 {
+			atomic.AddUint64(&b.negs, 1)
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *Nitro) bloomAdd(itm *Item) {
+	if m.bloom != nil {
+		m.bloom.add(itm.Bytes())
+	}
+}
+
+// MayContain returns false if no item with the key of bs was ever inserted.
+// It always returns true if the bloom filter is not enabled. Applications
+// can use it to skip iterator seeks for absent keys.
+func (m *Nitro) MayContain(bs []byte) bool {
+	return m.bloom == nil || m.bloom.mayContain(bs)
+}
+
+// BloomStats returns the bloom filter statistics
+func (m *Nitro) BloomStats() BloomStats {
+	if m.bloom == nil {
+		return BloomStats{}
+	}
+
+	return BloomStats{
+		Lookups:   atomic.LoadUint64(&m.bloom.lookups),
+		Negatives: atomic.LoadUint64(&m.bloom.negs),
+	}
+}
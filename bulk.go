@@ -0,0 +1,131 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"github.com/t3rm1n4l/nitro/skiplist"
+	"sync"
+	"unsafe"
+)
+
+// ErrUnsortedItems means bulk load input is not strictly ordered by the key comparator
+var ErrUnsortedItems = nerrors.New(nerrors.ErrInvalidConfig, "Bulk load items are not in sorted order")
+
+// ItemSource provides item bytes for bulk loading.
+// Next returns nil item bytes once the source is exhausted.
+type ItemSource interface {
+	Next() ([]byte, error)
+}
+
+// BulkLoad builds the Nitro instance from externally sorted item sources
+// using the concurrent bottom-up skiplist builder.
+// Items within a source should be in strictly increasing order and all items
+// of a source should be smaller than the items of the next source. Sources are
+// loaded concurrently using `concurr` workers.
+// BulkLoad should only be called on an empty Nitro instance. If it fails, the
+// instance should be closed and discarded.
+func (m *Nitro) BulkLoad(sources []ItemSource, concurr int, callb ItemCallback) (*Snapshot, error) {
+	var wg sync.WaitGroup
+	var nodeCallb skiplist.NodeCallback
+
+	concurr = m.acquireWorkers(concurr)
+	defer m.releaseWorkers(concurr)
+
+	b := skiplist.NewBuilderWithConfig(m.newStoreConfig())
+	b.SetItemSizeFunc(m.itemSizeFn())
+	segments := make([]*skiplist.Segment, len(sources))
+	// A panic is recorded in the error slot of the worker after the source errors
+	errors := make([]error, len(sources)+concurr)
+	firsts := make([]*Item, len(sources))
+	lasts := make([]*Item, len(sources))
+	wchan := make(chan int, len(sources))
+
+	if callb != nil {
+		nodeCallb = func(n *skiplist.Node) {
+			callb(&ItemEntry{itm: (*Item)(n.Item()), n: n})
+		}
+	}
+
+	for i := range sources {
+		segments[i] = b.NewSegment()
+		segments[i].SetNodeCallback(nodeCallb)
+		wchan <- i
+	}
+	close(wchan)
+
+	for i := 0; i < concurr; i++ {
+		wg.Add(1)
+		go func(wg *sync.WaitGroup, id int) {
+			defer wg.Done()
+			defer m.recoverTo(&errors[len(sources)+id])
+			m.setWorkerLabels(workerLoad)
+
+			for shard := range wchan {
+				src := sources[shard]
+				for {
+					bs, err := src.Next()
+					if err != nil {
+						errors[shard] = err
+						return
+					}
+
+					if bs == nil {
+						break
+					}
+
+					if last := lasts[shard]; last != nil && m.keyCmp(last.Bytes(), bs) >= 0 {
+						errors[shard] = ErrUnsortedItems
+						return
+					}
+
+					itm := m.newItem(bs, m.useMemoryMgmt)
+					m.assignSeq(itm)
+					m.bloomAdd(itm)
+					segments[shard].Add(unsafe.Pointer(itm))
+					if firsts[shard] == nil {
+						firsts[shard] = itm
+					}
+					lasts[shard] = itm
+				}
+			}
+		}(&wg, i)
+	}
+	wg.Wait()
+
+	// Partially loaded segments are also assembled so that Close() can
+	// free their items
+	m.store = b.Assemble(segments...)
+
+	for _, err := range errors {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var prev *Item
+	for i := range sources {
+		if firsts[i] == nil {
+			continue
+		}
+
+		if prev != nil && m.keyCmp(prev.Bytes(), firsts[i].Bytes()) >= 0 {
+			return nil, ErrUnsortedItems
+		}
+		prev = lasts[i]
+	}
+
+	stats := m.store.GetStats()
+	m.itemsCount = int64(stats.NodeCount)
+	if m.hindex != nil {
+		m.hindex.build(m)
+	}
+	return m.NewSnapshot()
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package bulkload implements item sources for ingesting externally sorted
+// data into Nitro using Nitro.BulkLoad().
+//
+// Sources produce key-value items encoded using nitro.KVToBytes(). Hence, the
+// Nitro instance should be configured with the nitro.CompareKV comparator.
+// Input data should be sorted by key and every key should be unique.
+package bulkload
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/t3rm1n4l/nitro"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"io"
+)
+
+// CSVRecordFn converts a CSV record into Nitro item bytes
+type CSVRecordFn func([]string) ([]byte, error)
+
+// KVRecord returns a CSVRecordFn which uses the keyCol and valCol columns as
+// key and value of the item
+func KVRecord(keyCol, valCol int) CSVRecordFn {
+	return func(rec []string) ([]byte, error) {
+		if keyCol >= len(rec) || valCol >= len(rec) {
+			return nil, nerrors.Wrap(nerrors.ErrCorrupt,
+				fmt.Errorf("bulkload: csv record has %d columns", len(rec)))
+		}
+		return nitro.KVToBytes([]byte(rec[keyCol]), []byte(rec[valCol])), nil
+	}
+}
+
+type csvSource struct {
+	r  *csv.Reader
+	fn CSVRecordFn
+}
+
+// NewCSVSource creates an item source from CSV records
+func NewCSVSource(r io.Reader, fn CSVRecordFn) nitro.ItemSource {
+	return &csvSource{r: csv.NewReader(r), fn: fn}
+}
+
+func (s *csvSource) Next() ([]byte, error) {
+	rec, err := s.r.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if _, ok := err.(*csv.ParseError); ok {
+		return nil, nerrors.Wrap(nerrors.ErrCorrupt, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return s.fn(rec)
+}
+
+// JSONKeyFn extracts the item key from a JSON object
+type JSONKeyFn func(map[string]interface{}) ([]byte, error)
+
+// FieldKey returns a JSONKeyFn which uses a top level string field as key
+func FieldKey(name string) JSONKeyFn {
+	return func(doc map[string]interface{}) ([]byte, error) {
+		s, ok := doc[name].(string)
+		if !ok {
+			return nil, nerrors.Wrap(nerrors.ErrCorrupt,
+				fmt.Errorf("bulkload: json field %q is not a string", name))
+		}
+		return []byte(s), nil
+	}
+}
+
+type jsonLinesSource struct {
+	s  *bufio.Scanner
+	fn JSONKeyFn
+}
+
+// NewJSONLinesSource creates an item source from newline delimited JSON
+// objects. The item value is the JSON line and its key is extracted using fn.
+func NewJSONLinesSource(r io.Reader, fn JSONKeyFn) nitro.ItemSource {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	return &jsonLinesSource{s: s, fn: fn}
+}
+
+func (s *jsonLinesSource) Next() ([]byte, error) {
+	for s.s.Scan() {
+		line := s.s.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		var doc map[string]interface{}
+		if err := json.Unmarshal(line, &doc); err != nil {
+			return nil, nerrors.Wrap(nerrors.ErrCorrupt, err)
+		}
+
+		k, err := s.fn(doc)
+		if err != nil {
+			return nil, err
+		}
+		return nitro.KVToBytes(k, line), nil
+	}
+
+	return nil, s.s.Err()
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package bulkload
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/t3rm1n4l/nitro"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"strings"
+	"testing"
+)
+
+func newDB() *nitro.Nitro {
+	cfg := nitro.DefaultConfig()
+	cfg.SetKeyComparator(nitro.CompareKV)
+	return nitro.NewWithConfig(cfg)
+}
+
+func verify(t *testing.T, snap *nitro.Snapshot, n int) {
+	i := 0
+	itr := snap.NewIterator()
+	defer itr.Close()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		k, _ := nitro.KVFromBytes(itr.Get())
+		if exp := fmt.Sprintf("key-%05d", i); string(k) != exp {
+			t.Errorf("Expected %s, got %s", exp, k)
+		}
+		i++
+	}
+
+	if i != n {
+		t.Errorf("Expected %d items, got %d", n, i)
+	}
+}
+
+func TestCSVSource(t *testing.T) {
+	var parts [4]bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&parts[i/250], "key-%05d,value-%d\n", i, i)
+	}
+
+	var sources []nitro.ItemSource
+	for i := range parts {
+		sources = append(sources, NewCSVSource(&parts[i], KVRecord(0, 1)))
+	}
+
+	db := newDB()
+	defer db.Close()
+	snap, err := db.BulkLoad(sources, 2, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+	verify(t, snap, 1000)
+}
+
+func TestJSONLinesSource(t *testing.T) {
+	var buf bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, "{\"id\": \"key-%05d\", \"v\": %d}\n", i, i)
+	}
+
+	db := newDB()
+	defer db.Close()
+	src := NewJSONLinesSource(&buf, FieldKey("id"))
+	snap, err := db.BulkLoad([]nitro.ItemSource{src}, 1, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+	verify(t, snap, 1000)
+}
+
+func TestSSTSource(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewSSTWriter(&buf)
+	for i := 0; i < 1000; i++ {
+		if err := w.Add([]byte(fmt.Sprintf("key-%05d", i)), []byte("v")); err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+	}
+	if err := w.Add([]byte("key-00000"), nil); err != ErrUnsortedKeys {
+		t.Errorf("Expected ErrUnsortedKeys, got %v", err)
+	}
+	w.Flush()
+
+	db := newDB()
+	defer db.Close()
+	snap, err := db.BulkLoad([]nitro.ItemSource{NewSSTSource(&buf)}, 1, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+	verify(t, snap, 1000)
+
+	// A corrupt key length larger than the file
+	bs := []byte{0x4e, 0x53, 0x53, 0x54, 0xff, 0xff, 0xff, 0xff, 'k'}
+	if _, err := NewSSTSource(bytes.NewReader(bs)).Next(); err != ErrBadSSTFile {
+		t.Errorf("Expected ErrBadSSTFile, got %v", err)
+	}
+}
+
+func TestMalformedSources(t *testing.T) {
+	for _, src := range []nitro.ItemSource{
+		NewCSVSource(strings.NewReader("key-00001\n"), KVRecord(0, 1)),
+		NewCSVSource(strings.NewReader("\"key-00001,x\n"), KVRecord(0, 1)),
+		NewJSONLinesSource(strings.NewReader("{\"id\": 1}\n"), FieldKey("id")),
+		NewJSONLinesSource(strings.NewReader("{\"id\"\n"), FieldKey("id")),
+	} {
+		if _, err := src.Next(); !errors.Is(err, nerrors.ErrCorrupt) {
+			t.Errorf("Expected corrupt input error, got %v", err)
+		}
+	}
+}
+
+func TestUnsortedSources(t *testing.T) {
+	db := newDB()
+	defer db.Close()
+	src := NewCSVSource(strings.NewReader("key-00002,x\nkey-00001,y\n"), KVRecord(0, 1))
+	if _, err := db.BulkLoad([]nitro.ItemSource{src}, 1, nil); err != nitro.ErrUnsortedItems {
+		t.Errorf("Expected ErrUnsortedItems, got %v", err)
+	}
+
+	db2 := newDB()
+	defer db2.Close()
+	src1 := NewCSVSource(strings.NewReader("key-00005,x\n"), KVRecord(0, 1))
+	src2 := NewCSVSource(strings.NewReader("key-00001,y\n"), KVRecord(0, 1))
+	if _, err := db2.BulkLoad([]nitro.ItemSource{src1, src2}, 2, nil); err != nitro.ErrUnsortedItems {
+		t.Errorf("Expected ErrUnsortedItems, got %v", err)
+	}
+}
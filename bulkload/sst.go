@@ -0,0 +1,135 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package bulkload
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/t3rm1n4l/nitro"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"io"
+)
+
+// SST file is a simple sorted key-value file format used for migrations.
+// It is not compatible with LevelDB or RocksDB table files.
+//
+// [4 byte magic][4 byte klen][key][4 byte vlen][value]...
+
+const sstMagic = 0x4e535354 // NSST
+
+var (
+	// ErrBadSSTFile means the input is not a SST file
+	ErrBadSSTFile = nerrors.New(nerrors.ErrCorrupt, "bulkload: invalid sst file")
+	// ErrUnsortedKeys means SSTWriter keys are not strictly increasing
+	ErrUnsortedKeys = nerrors.New(nerrors.ErrInvalidConfig, "bulkload: keys are not in sorted order")
+)
+
+// SSTWriter writes key-value pairs in SST file format
+type SSTWriter struct {
+	w       *bufio.Writer
+	lastKey []byte
+	hasKey  bool
+	hdr     [4]byte
+}
+
+// NewSSTWriter creates a SST file writer
+func NewSSTWriter(w io.Writer) (*SSTWriter, error) {
+	sw := &SSTWriter{w: bufio.NewWriter(w)}
+	binary.BigEndian.PutUint32(sw.hdr[:], sstMagic)
+	if _, err := sw.w.Write(sw.hdr[:]); err != nil {
+		return nil, err
+	}
+
+	return sw, nil
+}
+
+// Add appends a key-value pair. Keys should be added in increasing order.
+func (sw *SSTWriter) Add(k, v []byte) error {
+	if sw.hasKey && bytes.Compare(sw.lastKey, k) >= 0 {
+		return ErrUnsortedKeys
+	}
+
+	sw.lastKey = append(sw.lastKey[:0], k...)
+	sw.hasKey = true
+	for _, bs := range [][]byte{k, v} {
+		binary.BigEndian.PutUint32(sw.hdr[:], uint32(len(bs)))
+		if _, err := sw.w.Write(sw.hdr[:]); err != nil {
+			return err
+		}
+		if _, err := sw.w.Write(bs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush writes buffered data to the underlying writer
+func (sw *SSTWriter) Flush() error {
+	return sw.w.Flush()
+}
+
+type sstSource struct {
+	r     *bufio.Reader
+	hdr   [4]byte
+	start bool
+}
+
+// NewSSTSource creates an item source from a SST file
+func NewSSTSource(r io.Reader) nitro.ItemSource {
+	return &sstSource{r: bufio.NewReader(r)}
+}
+
+func (s *sstSource) readBytes() ([]byte, error) {
+	if _, err := io.ReadFull(s.r, s.hdr[:]); err != nil {
+		return nil, err
+	}
+
+	// The buffer grows with the bytes read rather than the length claimed
+	// by the header, which may be corrupt
+	var buf bytes.Buffer
+	n := int64(binary.BigEndian.Uint32(s.hdr[:]))
+	if _, err := io.CopyN(&buf, s.r, n); err != nil {
+		if err == io.EOF {
+			err = ErrBadSSTFile
+		}
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *sstSource) Next() ([]byte, error) {
+	if !s.start {
+		if _, err := io.ReadFull(s.r, s.hdr[:]); err != nil ||
+			binary.BigEndian.Uint32(s.hdr[:]) != sstMagic {
+			return nil, ErrBadSSTFile
+		}
+		s.start = true
+	}
+
+	k, err := s.readBytes()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	v, err := s.readBytes()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return nitro.KVToBytes(k, v), nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"fmt"
+	"math"
+	"runtime/debug"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Items are poisoned with this snapshot number before they are freed when
+// debug checks are enabled. Snapshot numbers never reach it.
+const freedItemSn = math.MaxUint32
+
+// EnableDebugChecks turns on runtime verification of snapshot and iterator
+// lifetimes. Misuse such as closing a snapshot more times than it was opened,
+// using an iterator after Close or reading items after they were freed
+// panics with a report of where the object was closed.
+// The checks are enabled by default in race detector builds.
+func (cfg *Config) EnableDebugChecks(enable bool) {
+	cfg.debugChecks = enable
+}
+
+func debugPanic(format string, args ...interface{}) {
+	panic(fmt.Sprintf("nitro: "+format, args...))
+}
+
+func (m *Nitro) poisonItem(itm *Item) {
+	if m.debugChecks {
+		itm.bornSn = freedItemSn
+		itm.deadSn = freedItemSn
+	}
+}
+
+func (it *Iterator) markClosed() {
+	if it.snap.db.debugChecks {
+		it.check()
+		it.closedBy = debug.Stack()
+	}
+}
+
+// checkClose records where the snapshot was released. The stack is captured
+// before the refcount is decremented and published atomically, since other
+// threads read it as soon as they observe the released refcount.
+func (s *Snapshot) checkClose(decr func() int32) int32 {
+	if !s.db.debugChecks {
+		return decr()
+	}
+
+	stack := debug.Stack()
+	refCount := decr()
+	if refCount == 0 {
+		atomic.StorePointer(&s.closedBy, unsafe.Pointer(&stack))
+	} else if refCount < 0 {
+		debugPanic("snapshot %d closed more times than it was opened\n"+
+			"snapshot was released at:\n%s", s.sn, s.releasedAt())
+	}
+
+	return refCount
+}
+
+func (s *Snapshot) releasedAt() []byte {
+	if p := atomic.LoadPointer(&s.closedBy); p != nil {
+		return *(*[]byte)(p)
+	}
+	return nil
+}
+
+func (it *Iterator) check() {
+	if !it.snap.db.debugChecks {
+		return
+	}
+
+	if it.closedBy != nil {
+		debugPanic("iterator on snapshot %d used after Close\n"+
+			"iterator was closed at:\n%s", it.snap.sn, it.closedBy)
+	}
+
+	if atomic.LoadInt32(&it.snap.refCount) <= 0 {
+		debugPanic("iterator outlived snapshot %d\n"+
+			"snapshot was released at:\n%s", it.snap.sn, it.snap.releasedAt())
+	}
+}
+
+func (it *Iterator) checkItem(itm *Item) {
+	if it.snap.db.debugChecks && itm.bornSn == freedItemSn && itm.deadSn == freedItemSn {
+		debugPanic("iterator on snapshot %d accessed a freed item", it.snap.sn)
+	}
+}
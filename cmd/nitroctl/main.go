@@ -0,0 +1,263 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Command nitroctl inspects and maintains Nitro disk backups.
+//
+//	nitroctl stats [flags] DIR
+//	nitroctl verify [flags] DIR
+//	nitroctl dump-keys [flags] [-start KEY] [-end KEY] [-limit N] DIR
+//	nitroctl backup [flags] [-format raw|block] [-start KEY] [-end KEY] SRC DST
+//
+// The backup command rewrites a backup into another directory. It drops
+// delta files, converts between file formats and, with a range, extracts a
+// subset of the items. Backups are loaded into memory, so the host needs
+// enough memory to hold the backup.
+//
+// Backups written by an instance using nitro.CompareKV, item metadata or
+// seqnos need the -kv, -itemmeta and -seqnos flags respectively.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/t3rm1n4l/nitro"
+	"io"
+	"os"
+	"runtime"
+)
+
+type options struct {
+	kv       bool
+	itemMeta int
+	seqnos   bool
+	start    string
+	end      string
+	limit    int
+	format   string
+}
+
+func (o *options) config() nitro.Config {
+	cfg := nitro.DefaultConfig()
+	if o.kv {
+		cfg.SetKeyComparator(nitro.CompareKV)
+	}
+
+	if o.itemMeta > 0 {
+		cfg.UseItemMetadata(o.itemMeta)
+	}
+
+	if o.seqnos {
+		cfg.UseSeqnos()
+	}
+
+	return cfg
+}
+
+func (o *options) item(key string) []byte {
+	if key == "" {
+		return nil
+	}
+
+	if o.kv {
+		return nitro.KVToBytes([]byte(key), nil)
+	}
+
+	return []byte(key)
+}
+
+func (o *options) load(dir string) (*nitro.Nitro, *nitro.Snapshot, error) {
+	db := nitro.NewWithConfig(o.config())
+	snap, err := db.LoadFromDiskWithOptions(dir, runtime.NumCPU(), nitro.LoadOptions{
+		StartItem: o.item(o.start),
+		EndItem:   o.item(o.end),
+	})
+	if err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+
+	return db, snap, nil
+}
+
+func (o *options) show(itm []byte) string {
+	if o.kv {
+		k, v := nitro.KVFromBytes(itm)
+		return fmt.Sprintf("%q = %q", k, v)
+	}
+
+	return fmt.Sprintf("%q", itm)
+}
+
+func stats(o *options, args []string, out io.Writer) error {
+	db, snap, err := o.load(args[0])
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	defer snap.Close()
+
+	info, err := db.BackupInfo(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "uuid: %s\n", info.UUID)
+	fmt.Fprintf(out, "label: %s\n", info.Label)
+	fmt.Fprintf(out, "created: %s\n", info.Created)
+	fmt.Fprintf(out, "seqno: %d\n", info.Seqno)
+	for _, ev := range db.Lineage() {
+		fmt.Fprintf(out, "lineage: %s %s %s\n", ev.Type, ev.UUID, ev.Time)
+	}
+	fmt.Fprintf(out, "items: %d\n", snap.Count())
+	fmt.Fprintf(out, "memory: %d\n", db.MemoryInUse())
+	fmt.Fprint(out, db.DumpStats())
+	return nil
+}
+
+// verify restores the backup with the file order and item count checks of
+// the restore enabled. The restored skiplist orders and deduplicates the
+// items, so it can not be used to detect corruption.
+func verify(o *options, args []string, out io.Writer) error {
+	db := nitro.NewWithConfig(o.config())
+	defer db.Close()
+
+	snap, err := db.LoadFromDiskWithOptions(args[0], runtime.NumCPU(), nitro.LoadOptions{Verify: true})
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	info, err := db.BackupInfo(args[0])
+	if err != nil {
+		return err
+	}
+
+	if snap.Count() != info.Items {
+		return fmt.Errorf("item count mismatch: restored %d, expected %d", snap.Count(), info.Items)
+	}
+
+	fmt.Fprintf(out, "ok: %d items\n", info.Items)
+	return nil
+}
+
+func dumpKeys(o *options, args []string, out io.Writer) error {
+	db, snap, err := o.load(args[0])
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	defer snap.Close()
+
+	itr := snap.NewIterator()
+	defer itr.Close()
+	n := 0
+	for itr.SeekFirst(); itr.Valid() && (o.limit <= 0 || n < o.limit); itr.Next() {
+		fmt.Fprintln(out, o.show(itr.Get()))
+		n++
+	}
+
+	return nil
+}
+
+func backup(o *options, args []string, out io.Writer) error {
+	cfg := o.config()
+	switch o.format {
+	case "", "raw":
+		cfg.SetFileType(nitro.RawdbFile)
+	case "block":
+		cfg.SetFileType(nitro.BlockdbFile)
+	default:
+		return fmt.Errorf("unknown format %q", o.format)
+	}
+
+	db := nitro.NewWithConfig(cfg)
+	defer db.Close()
+	snap, err := db.LoadFromDiskWithOptions(args[0], runtime.NumCPU(), nitro.LoadOptions{
+		StartItem: o.item(o.start),
+		EndItem:   o.item(o.end),
+	})
+	if err != nil {
+		return err
+	}
+	defer snap.Close()
+
+	if err := db.StoreToDisk(args[1], snap, runtime.NumCPU(), nil); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "stored %d items to %s\n", snap.Count(), args[1])
+	return nil
+}
+
+type command struct {
+	run   func(*options, []string, io.Writer) error
+	nargs int
+	usage string
+}
+
+var commands = map[string]command{
+	"stats":     {stats, 1, "DIR"},
+	"verify":    {verify, 1, "DIR"},
+	"dump-keys": {dumpKeys, 1, "DIR"},
+	"backup":    {backup, 2, "SRC DST"},
+}
+
+func usage(out io.Writer) {
+	fmt.Fprintln(out, "usage: nitroctl <stats|verify|dump-keys|backup> [flags] args")
+}
+
+func run(args []string, out io.Writer) error {
+	if len(args) < 1 {
+		usage(out)
+		return fmt.Errorf("missing command")
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage(out)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+
+	o := new(options)
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.BoolVar(&o.kv, "kv", false, "items use nitro.CompareKV encoding")
+	fs.IntVar(&o.itemMeta, "itemmeta", 0, "item metadata size")
+	fs.BoolVar(&o.seqnos, "seqnos", false, "items carry seqnos")
+	fs.StringVar(&o.start, "start", "", "first key of the range")
+	fs.StringVar(&o.end, "end", "", "key after the end of the range")
+	if args[0] == "dump-keys" {
+		fs.IntVar(&o.limit, "limit", 0, "maximum number of keys")
+	}
+	if args[0] == "backup" {
+		fs.StringVar(&o.format, "format", "raw", "backup file format (raw or block)")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(out, "usage: nitroctl %s [flags] %s\n", args[0], cmd.usage)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if fs.NArg() != cmd.nargs {
+		fs.Usage()
+		return fmt.Errorf("expected %d arguments", cmd.nargs)
+	}
+
+	return cmd.run(o, fs.Args(), out)
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "nitroctl:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/t3rm1n4l/nitro"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeBackup(t *testing.T, dir string, n int) {
+	cfg := nitro.DefaultConfig()
+	cfg.SetKeyComparator(nitro.CompareKV)
+	db := nitro.NewWithConfig(cfg)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.Put(nitro.KVToBytes([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprint(i))))
+	}
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+	if err := db.StoreToDisk(dir, snap, 4, nil); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "nitroctl")
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+	writeBackup(t, src, 1000)
+
+	var out bytes.Buffer
+	if err := run([]string{"verify", "-kv", src}, &out); err != nil || out.String() != "ok: 1000 items\n" {
+		t.Errorf("Unexpected verify result %v %q", err, out.String())
+	}
+
+	out.Reset()
+	if err := run([]string{"stats", "-kv", src}, &out); err != nil || !strings.Contains(out.String(), "items: 1000") {
+		t.Errorf("Unexpected stats result %v %q", err, out.String())
+	}
+
+	out.Reset()
+	args := []string{"dump-keys", "-kv", "-start", "key-0100", "-end", "key-0200", "-limit", "3", src}
+	if err := run(args, &out); err != nil {
+		t.Fatalf("dump-keys failed: %v", err)
+	}
+
+	exp := "\"key-0100\" = \"100\"\n\"key-0101\" = \"101\"\n\"key-0102\" = \"102\"\n"
+	if out.String() != exp {
+		t.Errorf("Unexpected keys %q", out.String())
+	}
+
+	out.Reset()
+	args = []string{"backup", "-kv", "-format", "block", "-start", "key-0500", src, dst}
+	if err := run(args, &out); err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	out.Reset()
+	if err := run([]string{"verify", "-kv", dst}, &out); err != nil || out.String() != "ok: 500 items\n" {
+		t.Errorf("Unexpected verify result %v %q", err, out.String())
+	}
+
+	if err := run([]string{"compact", src}, &out); err == nil {
+		t.Errorf("Expected unknown command error")
+	}
+
+	if err := run([]string{"verify"}, &out); err == nil {
+		t.Errorf("Expected argument error")
+	}
+
+	// List a data file twice, which the restore would deduplicate
+	files := filepath.Join(src, "data", "files.json")
+	bs, _ := ioutil.ReadFile(files)
+	var names []string
+	json.Unmarshal(bs, &names)
+	bs, _ = json.Marshal(append(names, names[0]))
+	ioutil.WriteFile(files, bs, 0660)
+
+	out.Reset()
+	if err := run([]string{"verify", "-kv", src}, &out); err == nil {
+		t.Errorf("Expected verify to detect corruption, got %q", out.String())
+	}
+}
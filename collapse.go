@@ -0,0 +1,161 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"github.com/t3rm1n4l/nitro/skiplist"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+/*
+* Snapshot collapse
+*
+* A closed snapshot is garbage collected only after all older snapshots are
+* collected. Hence, a long lived snapshot holds back the garbage lists of all
+* the newer snapshots which are already closed.
+*
+* A garbage list of snapshot n has the items deleted with deadSn = n. Such an
+* item is visible only to the snapshots in [bornSn, n). If every snapshot in
+* (P, n] is closed, where P is the newest live snapshot older than n, the
+* item is needed only if bornSn <= P.
+*
+* Collapse merges every run of consecutive closed snapshots newer than a live
+* snapshot P into a single snapshot covering the run. Items which are not
+* visible to P are handed over to the gc workers right away. The rest are
+* kept in the merged snapshot until P is collected.
+* */
+
+func (s *Snapshot) firstSn() uint32 {
+	if s.baseSn != 0 {
+		return s.baseSn
+	}
+	return s.sn
+}
+
+// CollapseSnapshots merges closed snapshots which are held back from garbage
+// collection by older live snapshots. Items deleted within a run of closed
+// snapshots are reclaimed immediately unless an older live snapshot can see
+// them. It is useful for workloads which create many snapshots, but retain
+// only a few of them. It returns the number of snapshots merged away.
+func (m *Nitro) CollapseSnapshots() int {
+	// Acquire gc ownership to modify the dead snapshot list
+	// Close() holds it forever once the instance is shutdown
+	for !atomic.CompareAndSwapInt32(&m.isGCRunning, 0, 1) {
+		if m.hasShutdown {
+			return 0
+		}
+		time.Sleep(time.Millisecond)
+	}
+	defer atomic.StoreInt32(&m.isGCRunning, 0)
+
+	m.collectDead(0)
+
+	var live []uint32
+	for _, snap := range m.GetSnapshots() {
+		live = append(live, snap.sn)
+	}
+
+	var runs [][]*Snapshot
+	var prevLive uint32
+	var last *Snapshot
+	buf := m.gcsnapshots.MakeBuf()
+	defer m.gcsnapshots.FreeBuf(buf)
+	iter := m.gcsnapshots.NewIterator(CompareSnapshot, buf)
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		snap := (*Snapshot)(iter.Get())
+		if m.retained(snap) {
+			break
+		}
+
+		p := newestBefore(live, snap.firstSn())
+		if last == nil || p != prevLive || snap.firstSn() != last.sn+1 {
+			runs = append(runs, nil)
+		}
+
+		runs[len(runs)-1] = append(runs[len(runs)-1], snap)
+		prevLive, last = p, snap
+	}
+	iter.Close()
+
+	var collapsed int
+	for _, run := range runs {
+		p := newestBefore(live, run[0].firstSn())
+		m.collapseRun(run, p)
+		collapsed += len(run) - 1
+	}
+
+	// Collect the snapshots closed meanwhile
+	m.collectDead(0)
+	return collapsed
+}
+
+// newestBefore returns the newest live snapshot older than sn
+func newestBefore(live []uint32, sn uint32) uint32 {
+	var p uint32
+	for _, l := range live {
+		if l >= sn {
+			break
+		}
+		p = l
+	}
+
+	return p
+}
+
+func (m *Nitro) collapseRun(run []*Snapshot, liveSn uint32) {
+	var keepHead, keepTail, freeHead, freeTail *skiplist.Node
+	var keepCount int64
+	appendTo := func(head, tail **skiplist.Node, n *skiplist.Node) {
+		if *tail == nil {
+			*head = n
+		} else {
+			(*tail).GClink = n
+		}
+		*tail = n
+	}
+
+	for _, snap := range run {
+		for n := snap.gclist; n != nil; {
+			next := n.GClink
+			n.GClink = nil
+			if (*Item)(n.Item()).bornSn <= liveSn {
+				appendTo(&keepHead, &keepTail, n)
+				keepCount++
+			} else {
+				appendTo(&freeHead, &freeTail, n)
+			}
+			n = next
+		}
+	}
+
+	buf := m.gcsnapshots.MakeBuf()
+	defer m.gcsnapshots.FreeBuf(buf)
+
+	last := run[len(run)-1]
+	merged := &Snapshot{
+		db:      m,
+		sn:      last.sn,
+		baseSn:  run[0].firstSn(),
+		count:   last.count,
+		gclist:  keepHead,
+		gcCount: keepCount,
+	}
+
+	for _, snap := range run {
+		m.gcsnapshots.Delete(unsafe.Pointer(snap), CompareSnapshot, buf, &m.gcsnapshots.Stats)
+	}
+	m.gcsnapshots.Insert(unsafe.Pointer(merged), CompareSnapshot, buf, &m.gcsnapshots.Stats)
+
+	if freeHead != nil {
+		m.sendGCList(freeHead)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DiffType describes how an item differs between two snapshots
+type DiffType int
+
+const (
+	// DiffAdded means the item exists only in the second snapshot
+	DiffAdded DiffType = iota
+	// DiffRemoved means the item exists only in the first snapshot
+	DiffRemoved
+	// DiffChanged means the key exists in both snapshots, but the item
+	// bytes differ
+	DiffChanged
+)
+
+func (t DiffType) String() string {
+	switch t {
+	case DiffAdded:
+		return "added"
+	case DiffRemoved:
+		return "removed"
+	case DiffChanged:
+		return "changed"
+	}
+	return fmt.Sprintf("DiffType(%d)", int(t))
+}
+
+// DiffCallback receives the differing items found by Compare(). The item of
+// the snapshot which lacks the key is nil. The items are valid only for the
+// duration of the callback.
+type DiffCallback func(t DiffType, a, b []byte)
+
+// CompareResult summarizes the difference between two snapshots
+type CompareResult struct {
+	Added     int64
+	Removed   int64
+	Changed   int64
+	Unchanged int64
+}
+
+// Equal returns true if the snapshots had identical items
+func (r CompareResult) Equal() bool {
+	return r.Added == 0 && r.Removed == 0 && r.Changed == 0
+}
+
+// Compare walks two snapshots in key order and counts the items which were
+// added, removed, changed or left unchanged from a to b. The snapshots may
+// belong to different Nitro instances, for instance to validate that two
+// replicas converged, but both should use the key comparator of a. If callb
+// is non-nil, it is invoked for every differing item. It returns
+// ErrSnapshotClosed if either snapshot is already closed.
+func Compare(a, b *Snapshot, callb DiffCallback) (r CompareResult, err error) {
+	cmp := a.db.keyCmp
+
+	itrA := a.NewIterator()
+	if itrA == nil {
+		return r, ErrSnapshotClosed
+	}
+	defer itrA.Close()
+
+	itrB := b.NewIterator()
+	if itrB == nil {
+		return r, ErrSnapshotClosed
+	}
+	defer itrB.Close()
+
+	itrA.SeekFirst()
+	itrB.SeekFirst()
+	for itrA.Valid() || itrB.Valid() {
+		var x, y []byte
+		c := 0
+		switch {
+		case !itrA.Valid():
+			y, c = itrB.Get(), 1
+		case !itrB.Valid():
+			x, c = itrA.Get(), -1
+		default:
+			x, y = itrA.Get(), itrB.Get()
+			c = cmp(x, y)
+		}
+
+		switch {
+		case c < 0:
+			r.Removed++
+			if callb != nil {
+				callb(DiffRemoved, x, nil)
+			}
+			itrA.Next()
+		case c > 0:
+			r.Added++
+			if callb != nil {
+				callb(DiffAdded, nil, y)
+			}
+			itrB.Next()
+		default:
+			if bytes.Equal(x, y) {
+				r.Unchanged++
+			} else {
+				r.Changed++
+				if callb != nil {
+					callb(DiffChanged, x, y)
+				}
+			}
+			itrA.Next()
+			itrB.Next()
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime/pprof"
+)
+
+const (
+	labelWorker   = "nitro_worker"
+	labelInstance = "nitro_instance"
+
+	workerGC     = "gc"
+	workerFree   = "free"
+	workerVisit  = "visitor"
+	workerLoad   = "loader"
+	workerDelta  = "delta-loader"
+	profileDebug = 1
+)
+
+// workerStartHook is invoked as workers start. It is used by tests to inject
+// failures.
+var workerStartHook func(worker string)
+
+// setWorkerLabels attributes the calling goroutine to a nitro subsystem in
+// cpu profiles and goroutine dumps
+func (m *Nitro) setWorkerLabels(worker string) {
+	if workerStartHook != nil {
+		workerStartHook(worker)
+	}
+
+	ctx := pprof.WithLabels(context.Background(),
+		pprof.Labels(labelWorker, worker, labelInstance, m.uuid))
+	pprof.SetGoroutineLabels(ctx)
+}
+
+// DebugDumpGoroutines writes the stack traces of all background goroutines
+// owned by the Nitro instance
+func (m *Nitro) DebugDumpGoroutines(w io.Writer) error {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, profileDebug); err != nil {
+		return err
+	}
+
+	// Goroutines with identical stacks and labels are grouped into records
+	// separated by an empty line
+	tag := []byte(fmt.Sprintf("%q:%q", labelInstance, m.uuid))
+	for _, rec := range bytes.Split(buf.Bytes(), []byte("\n\n")) {
+		if bytes.Contains(rec, tag) {
+			if _, err := w.Write(append(rec, '\n', '\n')); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
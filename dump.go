@@ -0,0 +1,287 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	defaultProgressInterval = time.Second
+	loadCancelCheckInterval = 1024
+)
+
+// DumpProgress describes the state of an ongoing disk backup
+type DumpProgress struct {
+	ItemsDone    int64
+	ItemsTotal   int64
+	BytesWritten int64
+	Elapsed      time.Duration
+	ETA          time.Duration
+}
+
+// DumpProgressCallback is periodically invoked during a disk backup.
+// It is invoked once more with the final progress after the backup finishes.
+type DumpProgressCallback func(DumpProgress)
+
+// DumpOptions configures a disk backup
+type DumpOptions struct {
+	// ItemCallback is invoked for every item written to the backup
+	ItemCallback ItemCallback
+
+	// RateLimit throttles backup writes to the given bytes/sec. A zero
+	// value means unlimited.
+	RateLimit int64
+
+	// Progress callback and the interval between its invocations
+	Progress         DumpProgressCallback
+	ProgressInterval time.Duration
+
+	// Label is stored with the backup and reported by BackupInfo()
+	Label string
+}
+
+type dumpTracker struct {
+	itemsDone    int64
+	bytesWritten int64
+	itemsTotal   int64
+	start        time.Time
+
+	rl *rateLimiter
+}
+
+func newDumpTracker(total int64, opts *DumpOptions) *dumpTracker {
+	dt := &dumpTracker{
+		itemsTotal: total,
+		start:      time.Now(),
+	}
+
+	if opts.RateLimit > 0 {
+		dt.rl = newRateLimiter(opts.RateLimit)
+	}
+
+	return dt
+}
+
+func (dt *dumpTracker) add(items, bytes int64) {
+	atomic.AddInt64(&dt.itemsDone, items)
+	atomic.AddInt64(&dt.bytesWritten, bytes)
+	if dt.rl != nil {
+		dt.rl.wait(bytes)
+	}
+}
+
+func (dt *dumpTracker) progress() DumpProgress {
+	p := DumpProgress{
+		ItemsDone:    atomic.LoadInt64(&dt.itemsDone),
+		ItemsTotal:   dt.itemsTotal,
+		BytesWritten: atomic.LoadInt64(&dt.bytesWritten),
+		Elapsed:      time.Since(dt.start),
+	}
+
+	if p.ItemsDone > 0 && p.ItemsTotal > p.ItemsDone {
+		p.ETA = time.Duration(float64(p.Elapsed) *
+			float64(p.ItemsTotal-p.ItemsDone) / float64(p.ItemsDone))
+	}
+
+	return p
+}
+
+// runProgress reports progress until the returned stop function is called
+func (dt *dumpTracker) runProgress(callb DumpProgressCallback, interval time.Duration) (stop func()) {
+	if callb == nil {
+		return func() {}
+	}
+
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				callb(dt.progress())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		wg.Wait()
+		callb(dt.progress())
+	}
+}
+
+// DumpHandle tracks a disk backup started by StoreToDiskAsync()
+type DumpHandle struct {
+	tracker *dumpTracker
+	done    chan struct{}
+	err     error
+}
+
+// StoreToDiskAsync starts a disk backup of the snapshot in the background
+// and returns immediately. Unlike StoreToDisk(), the backup opens its own
+// reference of the snapshot, so the caller may close the snapshot at any
+// time.
+func (m *Nitro) StoreToDiskAsync(dir string, snap *Snapshot, concurr int, opts DumpOptions) *DumpHandle {
+	h := &DumpHandle{
+		tracker: newDumpTracker(snap.Count(), &opts),
+		done:    make(chan struct{}),
+	}
+
+	if !snap.Open() {
+		h.err = ErrSnapshotClosed
+		close(h.done)
+		return h
+	}
+
+	go func() {
+		defer close(h.done)
+		h.err = m.storeToDisk(dir, snap, concurr, opts, h.tracker)
+	}()
+
+	return h
+}
+
+// Progress returns the current progress of the backup
+func (h *DumpHandle) Progress() DumpProgress {
+	return h.tracker.progress()
+}
+
+// Done returns a channel which is closed once the backup finishes
+func (h *DumpHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait waits for the backup to finish and returns its error
+func (h *DumpHandle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// Err returns the error of a finished backup. It returns nil while the
+// backup is running.
+func (h *DumpHandle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// LoadProgress describes the state of an ongoing restore from disk backup.
+// ItemsTotal and ETA are unknown for backups created by older versions.
+type LoadProgress struct {
+	ItemsDone  int64
+	ItemsTotal int64
+	BytesRead  int64
+	Elapsed    time.Duration
+	ETA        time.Duration
+}
+
+// LoadProgressCallback is periodically invoked during a restore.
+// It is invoked once more with the final progress after the restore finishes.
+type LoadProgressCallback func(LoadProgress)
+
+// LoadOptions configures a restore from disk backup
+type LoadOptions struct {
+	// ItemCallback is invoked for every item restored
+	ItemCallback ItemCallback
+
+	// Context aborts the restore with the context error once it is done
+	Context context.Context
+
+	// Progress callback and the interval between its invocations
+	Progress         LoadProgressCallback
+	ProgressInterval time.Duration
+
+	// StartItem and EndItem restrict the restore to items within
+	// [StartItem, EndItem) as ordered by the key comparator. A nil bound
+	// is unlimited. Backups in BlockdbFile format locate StartItem
+	// without reading the preceding items.
+	StartItem []byte
+	EndItem   []byte
+
+	// Verify checks that the items of every data file are in order, that
+	// the data files do not overlap and that the backup has as many items as
+	// were recorded when it was written. It fails the restore with
+	// ErrCorruptBackup otherwise. By default, the backup is trusted.
+	Verify bool
+}
+
+// checkRange returns -1 or 1 if the item is before or after the restore range
+func (opts *LoadOptions) checkRange(m *Nitro, itm *Item) int {
+	if opts.StartItem != nil && m.keyCmp(itm.Bytes(), opts.StartItem) < 0 {
+		return -1
+	}
+
+	if opts.EndItem != nil && m.keyCmp(itm.Bytes(), opts.EndItem) >= 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// verifyOrder checks that the restored data files do not overlap
+func (m *Nitro) verifyOrder(firsts, lasts []*Item) error {
+	var prev *Item
+	for i := range firsts {
+		if firsts[i] == nil {
+			continue
+		}
+
+		if prev != nil && m.iterCmp(unsafe.Pointer(prev), unsafe.Pointer(firsts[i])) >= 0 {
+			return ErrCorruptBackup
+		}
+		prev = lasts[i]
+	}
+
+	return nil
+}
+
+// verifyCount checks the number of items restored from data and delta files
+func (m *Nitro) verifyCount(meta *dumpMeta, opts *LoadOptions) error {
+	fullRestore := opts.StartItem == nil && opts.EndItem == nil
+	if meta != nil && fullRestore && m.itemsCount != meta.Items {
+		return ErrCorruptBackup
+	}
+
+	return nil
+}
+
+func (opts *LoadOptions) dumpProgressCallback() DumpProgressCallback {
+	if opts.Progress == nil {
+		return nil
+	}
+
+	return func(p DumpProgress) {
+		opts.Progress(LoadProgress{
+			ItemsDone:  p.ItemsDone,
+			ItemsTotal: p.ItemsTotal,
+			BytesRead:  p.BytesWritten,
+			Elapsed:    p.Elapsed,
+			ETA:        p.ETA,
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package errors defines the failure kinds shared by the nitro packages.
+// Every error returned by the packages which belongs to a kind matches it
+// using errors.Is(), so that callers can handle failure modes without
+// comparing error strings or package specific errors.
+package errors
+
+import (
+	stderrors "errors"
+)
+
+type kindError string
+
+func (e kindError) Error() string {
+	return string(e)
+}
+
+// Failure kinds
+var (
+	// ErrCorrupt means stored or encoded data is invalid
+	ErrCorrupt error = kindError("corrupt data")
+	// ErrNoSpace means the disk is out of space
+	ErrNoSpace error = kindError("no space left")
+	// ErrQuota means a configured or built-in limit was reached
+	ErrQuota error = kindError("limit reached")
+	// ErrClosed means an operation on a closed or shutdown object
+	ErrClosed error = kindError("closed")
+	// ErrInvalidConfig means a configuration or argument is not valid
+	ErrInvalidConfig error = kindError("invalid configuration")
+	// ErrTooLarge means an item or value does not fit the available space
+	// or format
+	ErrTooLarge error = kindError("too large")
+	// ErrBusy means a resource is in use by another owner
+	ErrBusy error = kindError("resource busy")
+	// ErrInternal means an internal invariant was violated
+	ErrInternal error = kindError("internal error")
+)
+
+var kinds = []error{ErrCorrupt, ErrNoSpace, ErrQuota, ErrClosed, ErrInvalidConfig, ErrTooLarge,
+	ErrBusy, ErrInternal}
+
+// Error is an error which belongs to a failure kind
+type Error struct {
+	kind error
+	msg  string
+	err  error
+}
+
+// New creates an error of the kind with the message
+func New(kind error, msg string) error {
+	return &Error{kind: kind, msg: msg}
+}
+
+// Wrap classifies err as the kind. The error message is unchanged and err
+// can still be matched using errors.Is() and errors.As(). It returns nil if
+// err is nil.
+func Wrap(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{kind: kind, err: err}
+}
+
+func (e *Error) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return e.msg
+}
+
+// Is matches the kind of the error
+func (e *Error) Is(target error) bool {
+	return target == e.kind
+}
+
+// Unwrap returns the wrapped error
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Kind returns the failure kind of err or nil if it does not belong to any
+func Kind(err error) error {
+	for _, k := range kinds {
+		if stderrors.Is(err, k) {
+			return k
+		}
+	}
+	return nil
+}
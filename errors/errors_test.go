@@ -0,0 +1,77 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package errors_test
+
+import (
+	stderrors "errors"
+	"github.com/t3rm1n4l/nitro"
+	"github.com/t3rm1n4l/nitro/bulkload"
+	. "github.com/t3rm1n4l/nitro/errors"
+	"github.com/t3rm1n4l/nitro/sstable"
+	"os"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	err := New(ErrCorrupt, "bad file")
+	if err.Error() != "bad file" || !stderrors.Is(err, ErrCorrupt) || stderrors.Is(err, ErrClosed) {
+		t.Errorf("Expected a corrupt error, got %v", err)
+	}
+
+	if Kind(err) != ErrCorrupt {
+		t.Errorf("Expected corrupt kind, got %v", Kind(err))
+	}
+
+	werr := Wrap(ErrNoSpace, &os.PathError{Op: "write", Path: "f", Err: os.ErrPermission})
+	if !stderrors.Is(werr, ErrNoSpace) || !stderrors.Is(werr, os.ErrPermission) {
+		t.Errorf("Expected wrapped error to match both, got %v", werr)
+	}
+
+	var perr *os.PathError
+	if !stderrors.As(werr, &perr) || werr.Error() != perr.Error() {
+		t.Errorf("Expected wrapped error message to be unchanged")
+	}
+
+	if Wrap(ErrNoSpace, nil) != nil || Kind(os.ErrNotExist) != nil {
+		t.Errorf("Expected no kind")
+	}
+}
+
+func TestPackageErrorKinds(t *testing.T) {
+	cases := []struct {
+		err  error
+		kind error
+	}{
+		{nitro.ErrMaxSnapshotsLimitReached, ErrQuota},
+		{nitro.ErrShutdown, ErrClosed},
+		{nitro.ErrDirtyBackup, ErrCorrupt},
+		{nitro.ErrUnsupportedBackup, ErrInvalidConfig},
+		{nitro.ErrCorruptBackup, ErrCorrupt},
+		{nitro.ErrSnapshotClosed, ErrClosed},
+		{nitro.ErrCorruptShared, ErrCorrupt},
+		{nitro.ErrItemTooLarge, ErrTooLarge},
+		{nitro.ErrUnsortedItems, ErrInvalidConfig},
+		{nitro.ErrInvalidToken, ErrInvalidConfig},
+		{nitro.ErrNoSnapshot, ErrClosed},
+		{nitro.ErrAlreadyOpen, ErrBusy},
+		{nitro.ErrReadOnly, ErrClosed},
+		{&nitro.InvariantError{Msg: "bad"}, ErrInternal},
+		{sstable.ErrClosed, ErrClosed},
+		{sstable.ErrUnsortedKeys, ErrInvalidConfig},
+		{bulkload.ErrBadSSTFile, ErrCorrupt},
+		{bulkload.ErrUnsortedKeys, ErrInvalidConfig},
+	}
+
+	for _, c := range cases {
+		if !stderrors.Is(c.err, c.kind) || Kind(c.err) != c.kind {
+			t.Errorf("Expected %q to be %q, got %v", c.err, c.kind, Kind(c.err))
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"fmt"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReadOnly means the Nitro instance stopped accepting writes after
+// repeated failures of its background workers. It is closed for writes.
+var ErrReadOnly = nerrors.New(nerrors.ErrClosed, "Nitro instance is read-only")
+
+const (
+	defaultWorkerRestartLimit = 3
+	defaultWorkerPanicWindow  = time.Minute
+	workerRestartBackoff      = 10 * time.Millisecond
+	maxWorkerRestartBackoff   = time.Second
+)
+
+// EventType identifies a Nitro event
+type EventType int
+
+const (
+	// EventWorkerPanic - a background worker panicked and will be restarted
+	EventWorkerPanic EventType = iota
+	// EventWorkerRestart - a background worker was restarted after a panic
+	EventWorkerRestart
+	// EventReadOnly - the instance switched to read-only mode
+	EventReadOnly
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventWorkerPanic:
+		return "worker-panic"
+	case EventWorkerRestart:
+		return "worker-restart"
+	case EventReadOnly:
+		return "read-only"
+	}
+	return "unknown"
+}
+
+// Event describes a notable state change of a Nitro instance
+type Event struct {
+	Type   EventType
+	Worker string
+	Err    error
+	Time   time.Time
+}
+
+// EventListener is invoked for Nitro events. It should not block.
+type EventListener func(Event)
+
+// SetEventListener configures the listener notified about background worker
+// failures and mode changes
+func (cfg *Config) SetEventListener(l EventListener) {
+	cfg.eventListener = l
+}
+
+// SetWorkerRestartLimit configures the number of background worker panics
+// after which the instance switches to read-only mode. Workers are always
+// restarted so that snapshots can still be collected.
+func (cfg *Config) SetWorkerRestartLimit(n int) {
+	cfg.restartLimit = n
+}
+
+// SetWorkerPanicWindow configures the window in which worker panics are
+// counted towards the restart limit. The count starts over once no worker
+// panicked for the window, so that isolated panics do not accumulate.
+func (cfg *Config) SetWorkerPanicWindow(d time.Duration) {
+	cfg.panicWindow = d
+}
+
+func (m *Nitro) notify(t EventType, worker string, err error) {
+	if m.eventListener != nil {
+		m.eventListener(Event{Type: t, Worker: worker, Err: err, Time: time.Now()})
+	}
+}
+
+// ReadOnly returns true if the instance has stopped accepting writes
+func (m *Nitro) ReadOnly() bool {
+	return atomic.LoadInt32(&m.readOnly) == 1
+}
+
+func (m *Nitro) setReadOnly() {
+	if atomic.CompareAndSwapInt32(&m.readOnly, 0, 1) {
+		m.logger.Log(LogError, "switching to read-only mode", Field("instance", m.uuid))
+		m.notify(EventReadOnly, "", ErrReadOnly)
+	}
+}
+
+// runRecovered runs fn and returns the recovered panic as an error
+func runRecovered(fn func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = nerrors.Wrap(nerrors.ErrInternal, fmt.Errorf("panic: %v\n%s", r, debug.Stack()))
+		}
+	}()
+
+	fn()
+	return nil
+}
+
+// superviseWorker runs a background worker until it returns normally.
+// A panicking worker is restarted with backoff. The work item being processed
+// during the panic is abandoned and its memory may be leaked.
+func (m *Nitro) superviseWorker(worker string, fn func()) {
+	limit := m.restartLimit
+	if limit <= 0 {
+		limit = defaultWorkerRestartLimit
+	}
+
+	window := m.panicWindow
+	if window <= 0 {
+		window = defaultWorkerPanicWindow
+	}
+
+	backoff := workerRestartBackoff
+	for {
+		err := runRecovered(fn)
+		if err == nil {
+			return
+		}
+
+		failures := m.health.recordPanic(window)
+		m.logger.Log(LogError, "background worker panicked",
+			Field("worker", worker), Field("failures", failures), Field("err", err))
+		m.notify(EventWorkerPanic, worker, err)
+		if int(failures) >= limit {
+			m.setReadOnly()
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxWorkerRestartBackoff {
+			backoff = maxWorkerRestartBackoff
+		}
+
+		m.notify(EventWorkerRestart, worker, nil)
+	}
+}
@@ -11,12 +11,12 @@ package nitro
 
 import "os"
 import "bufio"
-import "errors"
+import nerrors "github.com/t3rm1n4l/nitro/errors"
 
 var (
 	// DiskBlockSize - backup file reader and writer
 	DiskBlockSize     = 512 * 1024
-	errNotEnoughSpace = errors.New("Not enough space in the buffer")
+	errNotEnoughSpace = nerrors.New(nerrors.ErrTooLarge, "Not enough space in the buffer")
 )
 
 // FileType describes backup file format
@@ -27,6 +27,9 @@ const (
 	readerBufSize = 10000
 	// RawdbFile - backup file storage format
 	RawdbFile FileType = iota
+	// BlockdbFile - backup file storage format with sorted blocks and a
+	// trailing block index for random access
+	BlockdbFile
 )
 
 // FileWriter represents backup file writer
@@ -43,25 +46,43 @@ type FileReader interface {
 	Close() error
 }
 
+// FileSeeker is implemented by backup file readers which support random access.
+// Seek positions the reader such that the next ReadItem() returns the first
+// item greater than or equal to the provided item bytes.
+type FileSeeker interface {
+	Seek(bs []byte) error
+}
+
 func (m *Nitro) newFileWriter(t FileType) FileWriter {
 	var w FileWriter
-	if t == RawdbFile {
+	switch t {
+	case RawdbFile:
 		w = &rawFileWriter{db: m}
+	case BlockdbFile:
+		w = &blockFileWriter{db: m}
 	}
 	return w
 }
 
+// NewFileReader creates a reader for backup files of the given format
+func (m *Nitro) NewFileReader(t FileType) FileReader {
+	return m.newFileReader(t)
+}
+
 func (m *Nitro) newFileReader(t FileType) FileReader {
 	var r FileReader
-	if t == RawdbFile {
+	switch t {
+	case RawdbFile:
 		r = &rawFileReader{db: m}
+	case BlockdbFile:
+		r = &blockFileReader{db: m}
 	}
 	return r
 }
 
 type rawFileWriter struct {
 	db   *Nitro
-	fd   *os.File
+	fd   File
 	w    *bufio.Writer
 	buf  []byte
 	path string
@@ -69,7 +90,7 @@ type rawFileWriter struct {
 
 func (f *rawFileWriter) Open(path string) error {
 	var err error
-	f.fd, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0755)
+	f.fd, err = f.db.openFile(path, os.O_WRONLY|os.O_CREATE, 0755)
 	if err == nil {
 		f.buf = make([]byte, encodeBufSize)
 		f.w = bufio.NewWriterSize(f.fd, DiskBlockSize)
@@ -84,17 +105,21 @@ func (f *rawFileWriter) WriteItem(itm *Item) error {
 func (f *rawFileWriter) Close() error {
 	terminator := &Item{}
 
-	if err := f.WriteItem(terminator); err != nil {
-		return err
+	err := f.WriteItem(terminator)
+	if err == nil {
+		err = f.w.Flush()
 	}
 
-	f.w.Flush()
-	return f.fd.Close()
+	if cerr := f.fd.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
 }
 
 type rawFileReader struct {
 	db   *Nitro
-	fd   *os.File
+	fd   File
 	r    *bufio.Reader
 	buf  []byte
 	path string
@@ -102,7 +127,7 @@ type rawFileReader struct {
 
 func (f *rawFileReader) Open(path string) error {
 	var err error
-	f.fd, err = os.Open(path)
+	f.fd, err = f.db.openFile(path, os.O_RDONLY, 0)
 	if err == nil {
 		f.buf = make([]byte, encodeBufSize)
 		f.r = bufio.NewReaderSize(f.fd, DiskBlockSize)
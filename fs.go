@@ -0,0 +1,287 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// File is a file opened through a FileSystem
+type File interface {
+	io.Reader
+	io.Writer
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// FileSystem abstracts the file access performed by disk backup and restore.
+// Errors for missing files should satisfy os.IsNotExist().
+type FileSystem interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	MkdirAll(path string, perm os.FileMode) error
+}
+
+// OSFileSystem is the FileSystem backed by the operating system
+type OSFileSystem struct{}
+
+// OpenFile opens the named file using os.OpenFile()
+func (OSFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+// MkdirAll creates a directory with its parents using os.MkdirAll()
+func (OSFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+func readFile(fs FileSystem, name string) ([]byte, error) {
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+func writeFile(fs FileSystem, name string, data []byte, perm os.FileMode) error {
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// MemFileSystem is an in-memory FileSystem
+type MemFileSystem struct {
+	sync.Mutex
+	files map[string]*memFileData
+	dirs  map[string]bool
+	locks map[string]int
+}
+
+// NewMemFileSystem creates an empty in-memory filesystem
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{
+		files: make(map[string]*memFileData),
+		dirs:  map[string]bool{".": true, "/": true},
+	}
+}
+
+type memFileData struct {
+	sync.RWMutex
+	name    string
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// OpenFile opens the named file. The parent directory should exist.
+func (fs *MemFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	name = filepath.Clean(name)
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	fd, ok := fs.files[name]
+	switch {
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case !ok:
+		if !fs.dirs[filepath.Dir(name)] {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		fd = &memFileData{name: name, mode: perm, modTime: time.Now()}
+		fs.files[name] = fd
+	}
+
+	if flag&os.O_TRUNC != 0 {
+		fd.Lock()
+		fd.data = nil
+		fd.Unlock()
+	}
+
+	f := &memFile{fd: fd, flag: flag}
+	if flag&os.O_APPEND != 0 {
+		f.pos = int64(len(fd.data))
+	}
+
+	return f, nil
+}
+
+// MkdirAll creates a directory along with its parents
+func (fs *MemFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	for p := filepath.Clean(path); !fs.dirs[p]; p = filepath.Dir(p) {
+		fs.dirs[p] = true
+	}
+
+	return nil
+}
+
+// Files returns the names of all files in the filesystem
+func (fs *MemFileSystem) Files() []string {
+	fs.Lock()
+	defer fs.Unlock()
+
+	var names []string
+	for name := range fs.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type memFile struct {
+	fd     *memFileData
+	flag   int
+	pos    int64
+	closed bool
+}
+
+func (f *memFile) checkOpen(op string) error {
+	if f.closed {
+		return &os.PathError{Op: op, Path: f.fd.name, Err: os.ErrClosed}
+	}
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	n, err := f.ReadAt(p, f.pos)
+	f.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (f *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.checkOpen("read"); err != nil {
+		return 0, err
+	}
+
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == os.O_WRONLY {
+		return 0, &os.PathError{Op: "read", Path: f.fd.name, Err: os.ErrPermission}
+	}
+
+	f.fd.RLock()
+	defer f.fd.RUnlock()
+
+	if off >= int64(len(f.fd.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.fd.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if err := f.checkOpen("write"); err != nil {
+		return 0, err
+	}
+
+	if f.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &os.PathError{Op: "write", Path: f.fd.name, Err: os.ErrPermission}
+	}
+
+	f.fd.Lock()
+	defer f.fd.Unlock()
+
+	if f.flag&os.O_APPEND != 0 {
+		f.pos = int64(len(f.fd.data))
+	}
+
+	if end := f.pos + int64(len(p)); end > int64(len(f.fd.data)) {
+		f.fd.data = append(f.fd.data, make([]byte, end-int64(len(f.fd.data)))...)
+	}
+
+	copy(f.fd.data[f.pos:], p)
+	f.pos += int64(len(p))
+	f.fd.modTime = time.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.checkOpen("seek"); err != nil {
+		return 0, err
+	}
+
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		f.fd.RLock()
+		offset += int64(len(f.fd.data))
+		f.fd.RUnlock()
+	}
+
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.fd.name, Err: os.ErrInvalid}
+	}
+
+	f.pos = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	if err := f.checkOpen("close"); err != nil {
+		return err
+	}
+
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fd.RLock()
+	defer f.fd.RUnlock()
+
+	return memFileInfo{
+		name:    filepath.Base(f.fd.name),
+		size:    int64(len(f.fd.data)),
+		mode:    f.fd.mode,
+		modTime: f.fd.modTime,
+	}, nil
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() interface{}   { return nil }
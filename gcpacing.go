@@ -0,0 +1,47 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"sync/atomic"
+)
+
+// Number of items unlinked by a gc worker between pacing checks
+const gcPaceBatch = 64
+
+// GCPacing controls the rate of background garbage collection
+type GCPacing struct {
+	// ItemsPerSec limits the rate at which the gc workers unlink deleted
+	// items. A zero value means unlimited.
+	ItemsPerSec int64
+
+	// Manual disables garbage collection on snapshot close. Garbage is
+	// collected only when GC() or GCWithBudget() is called.
+	Manual bool
+}
+
+// SetGCPacing configures the garbage collection pacing. By default, garbage
+// is collected as fast as possible once a snapshot is closed.
+func (cfg *Config) SetGCPacing(p GCPacing) {
+	cfg.gcPacing = p
+}
+
+// GCWithBudget hands over the garbage of collectable snapshots to the gc
+// workers until about `items` deleted items are queued. The garbage of at
+// least one snapshot is queued if any snapshot is collectable. It returns
+// the number of items queued, or zero if another collection is running.
+func (m *Nitro) GCWithBudget(items int) int {
+	if items <= 0 || !atomic.CompareAndSwapInt32(&m.isGCRunning, 0, 1) {
+		return 0
+	}
+	defer atomic.StoreInt32(&m.isGCRunning, 0)
+
+	return m.collectDead(items)
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"github.com/t3rm1n4l/nitro/nodetable"
+	"github.com/t3rm1n4l/nitro/skiplist"
+	"hash/crc32"
+	"sync"
+	"unsafe"
+)
+
+const defaultHashIndexShards = 64
+
+// HashIndex configures a hash index from keys to the skiplist nodes of the
+// live items. It is not supported in multiset mode.
+type HashIndex struct {
+	// Shards is the number of independently locked nodetables (default 64)
+	Shards int
+
+	// Key optionally extracts the key from item bytes. It should agree with
+	// the key comparator, for example, returning the key of items encoded
+	// using KVToBytes().
+	Key func([]byte) []byte
+}
+
+// UseHashIndex enables a hash index which lets Writer.GetNode(), Delete()
+// and Delete2() find items without a skiplist search. Every live item costs
+// about 42 bytes of memory in the index. Scans still use the skiplist.
+func (cfg *Config) UseHashIndex(h HashIndex) {
+	cfg.hashIndex = &h
+}
+
+type hashIndexShard struct {
+	sync.Mutex
+	nt *nodetable.NodeTable
+}
+
+type hashIndex struct {
+	shards []hashIndexShard
+	keyFn  func([]byte) []byte
+}
+
+func (m *Nitro) newHashIndex(cfg HashIndex) *hashIndex {
+	n := cfg.Shards
+	if n <= 0 {
+		n = defaultHashIndexShards
+	}
+
+	h := &hashIndex{shards: make([]hashIndexShard, n), keyFn: cfg.Key}
+	equal := func(p unsafe.Pointer, bs []byte) bool {
+		itm := (*Item)((*skiplist.Node)(p).Item())
+		return m.keyCmp(itm.Bytes(), bs) == 0
+	}
+
+	for i := range h.shards {
+		h.shards[i].nt = nodetable.New(h.hash, equal)
+	}
+
+	return h
+}
+
+func (h *hashIndex) hash(bs []byte) uint32 {
+	if h.keyFn != nil {
+		bs = h.keyFn(bs)
+	}
+	return crc32.ChecksumIEEE(bs)
+}
+
+func (h *hashIndex) shard(bs []byte) *hashIndexShard {
+	return &h.shards[h.hash(bs)%uint32(len(h.shards))]
+}
+
+func (h *hashIndex) get(bs []byte) *skiplist.Node {
+	s := h.shard(bs)
+	s.Lock()
+	defer s.Unlock()
+	return (*skiplist.Node)(s.nt.Get(bs))
+}
+
+func (h *hashIndex) update(n *skiplist.Node) {
+	bs := (*Item)(n.Item()).Bytes()
+	s := h.shard(bs)
+	s.Lock()
+	s.nt.Update(bs, unsafe.Pointer(n))
+	s.Unlock()
+}
+
+// remove deletes the entry only if it still refers to the node, since the
+// key may have been inserted again by another writer
+func (h *hashIndex) remove(n *skiplist.Node) {
+	bs := (*Item)(n.Item()).Bytes()
+	s := h.shard(bs)
+	s.Lock()
+	if s.nt.Get(bs) == unsafe.Pointer(n) {
+		s.nt.Remove(bs)
+	}
+	s.Unlock()
+}
+
+// build indexes the live items of a store which was assembled without
+// going through the writers
+func (h *hashIndex) build(m *Nitro) {
+	buf := m.store.MakeBuf()
+	defer m.store.FreeBuf(buf)
+	iter := m.store.NewIterator(m.iterCmp, buf)
+	defer iter.Close()
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		if (*Item)(iter.Get()).deadSn == 0 {
+			h.update(iter.GetNode())
+		}
+	}
+}
+
+func (h *hashIndex) memoryInUse() (sz int64) {
+	for i := range h.shards {
+		s := &h.shards[i]
+		s.Lock()
+		sz += s.nt.MemoryInUse()
+		s.Unlock()
+	}
+	return
+}
+
+func (h *hashIndex) close() {
+	for i := range h.shards {
+		h.shards[i].nt.Close()
+	}
+}
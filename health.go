@@ -0,0 +1,150 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthStatus is a point in time report of the state of a Nitro instance
+type HealthStatus struct {
+	// Healthy is false if the instance is shutdown, read-only or a
+	// background worker is not running
+	Healthy  bool
+	Shutdown bool
+	ReadOnly bool
+
+	// Running and expected number of background gc and free workers
+	GCWorkers       int
+	FreeWorkers     int
+	ExpectedWorkers int
+	WorkerPanics    int
+
+	LastGC      time.Time
+	LastDump    time.Time
+	LastRestore time.Time
+
+	// Failed disk backups and restores along with the most recent error
+	DumpErrors    int64
+	RestoreErrors int64
+	LastError     string
+
+	// MemoryLimit is the cgroup memory limit of the process, or 0 if unlimited
+	MemoryInUse      int64
+	MemoryLimit      int64
+	LiveSnapshots    int
+	PendingSnapshots int
+}
+
+type healthState struct {
+	gcWorkers    int32
+	freeWorkers  int32
+	workerPanics int32
+
+	lastGC      int64
+	lastDump    int64
+	lastRestore int64
+
+	dumpErrors    int64
+	restoreErrors int64
+
+	sync.Mutex
+	lastErr error
+
+	// Worker panics since the count started over
+	recentPanics int32
+	lastPanic    time.Time
+}
+
+// recordPanic returns the number of worker panics within the window of
+// each other
+func (h *healthState) recordPanic(window time.Duration) int32 {
+	atomic.AddInt32(&h.workerPanics, 1)
+	h.Lock()
+	defer h.Unlock()
+
+	now := time.Now()
+	if now.Sub(h.lastPanic) > window {
+		h.recentPanics = 0
+	}
+	h.lastPanic = now
+	h.recentPanics++
+	return h.recentPanics
+}
+
+func (h *healthState) recordErr(counter *int64, err error) {
+	atomic.AddInt64(counter, 1)
+	h.Lock()
+	h.lastErr = err
+	h.Unlock()
+}
+
+func (h *healthState) recordDump(err error) {
+	if err != nil {
+		h.recordErr(&h.dumpErrors, err)
+		return
+	}
+	atomic.StoreInt64(&h.lastDump, time.Now().UnixNano())
+}
+
+func (h *healthState) recordRestore(err error) {
+	if err != nil {
+		h.recordErr(&h.restoreErrors, err)
+		return
+	}
+	atomic.StoreInt64(&h.lastRestore, time.Now().UnixNano())
+}
+
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// Health returns the health status of the Nitro instance. It is cheap enough
+// to be polled by readiness probes.
+func (m *Nitro) Health() HealthStatus {
+	h := &m.health
+	s := HealthStatus{
+		Shutdown:         m.hasShutdown,
+		ReadOnly:         m.ReadOnly(),
+		WorkerPanics:     int(atomic.LoadInt32(&h.workerPanics)),
+		GCWorkers:        int(atomic.LoadInt32(&h.gcWorkers)),
+		FreeWorkers:      int(atomic.LoadInt32(&h.freeWorkers)),
+		ExpectedWorkers:  m.numWriters(),
+		LastGC:           unixNanoTime(atomic.LoadInt64(&h.lastGC)),
+		LastDump:         unixNanoTime(atomic.LoadInt64(&h.lastDump)),
+		LastRestore:      unixNanoTime(atomic.LoadInt64(&h.lastRestore)),
+		DumpErrors:       atomic.LoadInt64(&h.dumpErrors),
+		RestoreErrors:    atomic.LoadInt64(&h.restoreErrors),
+		MemoryInUse:      m.MemoryInUse(),
+		MemoryLimit:      MemoryLimit(),
+		LiveSnapshots:    int(m.snapshots.GetStats().NodeCount),
+		PendingSnapshots: int(m.gcsnapshots.GetStats().NodeCount),
+	}
+
+	h.Lock()
+	if h.lastErr != nil {
+		s.LastError = h.lastErr.Error()
+	}
+	h.Unlock()
+
+	expectedFree := 0
+	if m.useMemoryMgmt {
+		expectedFree = s.ExpectedWorkers
+	}
+
+	s.Healthy = !s.Shutdown && !s.ReadOnly && s.GCWorkers == s.ExpectedWorkers &&
+		s.FreeWorkers == expectedFree
+	return s
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"fmt"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"runtime/debug"
+)
+
+// PanicPolicy decides how invariant violations and panics in the worker
+// goroutines of foreground operations are handled
+type PanicPolicy int
+
+const (
+	// PanicOnViolation crashes the process with a panic (default)
+	PanicOnViolation PanicPolicy = iota
+	// ErrorOnViolation converts the failure into an *InvariantError returned
+	// by the operation. It is meant for embedders which must never crash the
+	// host process.
+	ErrorOnViolation
+)
+
+// InvariantError is returned for invariant violations when ErrorOnViolation
+// policy is configured
+type InvariantError struct {
+	Msg   string
+	Stack []byte
+}
+
+func (e *InvariantError) Error() string {
+	return "nitro: invariant violation: " + e.Msg
+}
+
+// Is matches the ErrInternal failure kind
+func (e *InvariantError) Is(target error) bool {
+	return target == nerrors.ErrInternal
+}
+
+// SetPanicPolicy configures handling of invariant violations
+func (cfg *Config) SetPanicPolicy(p PanicPolicy) {
+	cfg.panicPolicy = p
+}
+
+// invariant reports a violation. It panics or returns an error as per the policy.
+func (m *Nitro) invariant(msg string) error {
+	if m.panicPolicy != ErrorOnViolation {
+		panic(msg)
+	}
+
+	err := &InvariantError{Msg: msg, Stack: debug.Stack()}
+	m.logger.Log(LogError, "invariant violation", Field("msg", msg), Field("stack", string(err.Stack)))
+	return err
+}
+
+// recoverTo converts a panic in a worker goroutine into an error stored in
+// *errp as per the policy. It should be deferred.
+func (m *Nitro) recoverTo(errp *error) {
+	if m.panicPolicy != ErrorOnViolation {
+		return
+	}
+
+	if r := recover(); r != nil {
+		err := &InvariantError{Msg: fmt.Sprint(r), Stack: debug.Stack()}
+		m.logger.Log(LogError, "worker panicked", Field("msg", err.Msg), Field("stack", string(err.Stack)))
+		*errp = err
+	}
+}
@@ -12,11 +12,17 @@ package nitro
 import (
 	"bytes"
 	"encoding/binary"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"github.com/t3rm1n4l/nitro/skiplist"
 	"io"
-	"reflect"
+	"math"
 	"unsafe"
 )
 
+// ErrItemTooLarge means an item does not fit the 2 byte length of the disk
+// backup format
+var ErrItemTooLarge = nerrors.New(nerrors.ErrTooLarge, "Item is too large for disk backup")
+
 var itemHeaderSize = unsafe.Sizeof(Item{})
 
 // Item represents nitro item header
@@ -38,12 +44,13 @@ func (m *Nitro) newItem(data []byte, useMM bool) (itm *Item) {
 
 func (m *Nitro) freeItem(itm *Item) {
 	if m.useMemoryMgmt {
+		m.poisonItem(itm)
 		m.freeFun(unsafe.Pointer(itm))
 	}
 }
 
 func (m *Nitro) allocItem(l int, useMM bool) (itm *Item) {
-	blockSize := itemHeaderSize + uintptr(l)
+	blockSize := itemHeaderSize + uintptr(l) + uintptr(m.metaBlockSize())
 	if useMM {
 		itm = (*Item)(m.mallocFun(int(blockSize)))
 		itm.deadSn = 0
@@ -64,6 +71,10 @@ func (m *Nitro) EncodeItem(itm *Item, buf []byte, w io.Writer) error {
 		return errNotEnoughSpace
 	}
 
+	if itm.dataLen > math.MaxUint16 {
+		return ErrItemTooLarge
+	}
+
 	binary.BigEndian.PutUint16(buf[0:2], uint16(itm.dataLen))
 	if _, err := w.Write(buf[0:2]); err != nil {
 		return err
@@ -72,9 +83,20 @@ func (m *Nitro) EncodeItem(itm *Item, buf []byte, w io.Writer) error {
 		return err
 	}
 
+	// Terminator items do not carry metadata
+	if m.metaBlockSize() > 0 && itm.dataLen > 0 {
+		if _, err := w.Write(m.itemMetaBlock(itm)); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func (m *Nitro) encodedSize(itm *Item) int64 {
+	return int64(2 + int(itm.dataLen) + m.metaBlockSize())
+}
+
 // DecodeItem decodes encoded [2 byte len][item_bytes] format.
 func (m *Nitro) DecodeItem(buf []byte, r io.Reader) (*Item, error) {
 	if _, err := io.ReadFull(r, buf[0:2]); err != nil {
@@ -85,7 +107,13 @@ func (m *Nitro) DecodeItem(buf []byte, r io.Reader) (*Item, error) {
 	if l > 0 {
 		itm := m.allocItem(int(l), m.useMemoryMgmt)
 		data := itm.Bytes()
-		_, err := io.ReadFull(r, data)
+		if _, err := io.ReadFull(r, data); err != nil || m.metaBlockSize() == 0 {
+			return itm, err
+		}
+		_, err := io.ReadFull(r, m.itemMetaBlock(itm))
+		if err == nil && m.hasSeqno() {
+			m.observeSeq(itm)
+		}
 		return itm, err
 	}
 
@@ -94,14 +122,8 @@ func (m *Nitro) DecodeItem(buf []byte, r io.Reader) (*Item, error) {
 
 // Bytes return item data bytes
 func (itm *Item) Bytes() (bs []byte) {
-	l := itm.dataLen
-	dataOffset := uintptr(unsafe.Pointer(itm)) + itemHeaderSize
-
-	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&bs))
-	hdr.Data = dataOffset
-	hdr.Len = int(l)
-	hdr.Cap = hdr.Len
-	return
+	data := unsafe.Add(unsafe.Pointer(itm), itemHeaderSize)
+	return unsafe.Slice((*byte)(data), itm.dataLen)
 }
 
 // ItemSize returns total bytes consumed by item representation
@@ -110,6 +132,37 @@ func ItemSize(p unsafe.Pointer) int {
 	return int(itemHeaderSize + uintptr(itm.dataLen))
 }
 
+func (m *Nitro) itemSizeFn() skiplist.ItemSizeFn {
+	metaSize := m.metaBlockSize()
+	if metaSize == 0 {
+		return ItemSize
+	}
+
+	return func(p unsafe.Pointer) int {
+		return ItemSize(p) + metaSize
+	}
+}
+
+// ItemMeta returns the fixed size metadata block stored next to the item data
+// It returns nil if item metadata is not enabled for the Nitro instance.
+func (m *Nitro) ItemMeta(itm *Item) (bs []byte) {
+	return m.metaBytes(itm, m.metaSize)
+}
+
+// itemMetaBlock returns the user metadata along with internal item metadata
+func (m *Nitro) itemMetaBlock(itm *Item) []byte {
+	return m.metaBytes(itm, m.metaBlockSize())
+}
+
+func (m *Nitro) metaBytes(itm *Item, size int) (bs []byte) {
+	if size == 0 {
+		return nil
+	}
+
+	meta := unsafe.Add(unsafe.Pointer(itm), itemHeaderSize+uintptr(itm.dataLen))
+	return unsafe.Slice((*byte)(meta), size)
+}
+
 // KVToBytes encodes key-value pair to item bytes which can be passed
 // to the Put() and Delete() methods.
 func KVToBytes(k, v []byte) []byte {
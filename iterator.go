@@ -11,6 +11,7 @@ package nitro
 
 import (
 	"github.com/t3rm1n4l/nitro/skiplist"
+	"time"
 	"unsafe"
 )
 
@@ -19,9 +20,52 @@ type Iterator struct {
 	count       int
 	refreshRate int
 
+	refreshInterval time.Duration
+	lastRefresh     time.Time
+	// Item count of the next refresh interval check
+	nextCheck int
+
 	snap *Snapshot
 	iter *skiplist.Iterator
 	buf  *skiplist.ActionBuffer
+
+	closedBy []byte
+
+	limits    *IteratorLimits
+	yielded   int
+	bytes     int64
+	start     time.Time
+	truncated bool
+}
+
+// Number of items between checks of the iterator refresh interval
+const refreshCheckInterval = 256
+
+// IteratorRefresh configures the automatic refresh of the accessor tokens
+// held by iterators. A long running scan holds back the reclamation of the
+// items deleted meanwhile until it refreshes. Zero values disable the
+// corresponding trigger.
+type IteratorRefresh struct {
+	// Items is the number of items visited between refreshes
+	Items int
+	// Interval is the time between refreshes
+	Interval time.Duration
+}
+
+// SetIteratorRefresh configures the automatic refresh of all iterators
+// created from snapshots. By default, iterators refresh only when Refresh()
+// is called or a refresh rate is set using SetRefreshRate().
+func (cfg *Config) SetIteratorRefresh(r IteratorRefresh) {
+	cfg.iterRefresh = r
+}
+
+// IteratorLimits bound the amount of work done by an iterator after a seek.
+// Once a limit is reached, Valid() returns false and Truncated() returns true
+// if more items were available. Zero values are unlimited.
+type IteratorLimits struct {
+	MaxItems    int
+	MaxBytes    int64
+	MaxDuration time.Duration
 }
 
 func (it *Iterator) skipUnwanted() {
@@ -30,6 +74,7 @@ loop:
 		return
 	}
 	itm := (*Item)(it.iter.Get())
+	it.checkItem(itm)
 	if itm.bornSn > it.snap.sn || (itm.deadSn > 0 && itm.deadSn <= it.snap.sn) {
 		it.iter.Next()
 		it.count++
@@ -39,6 +84,8 @@ loop:
 
 // SeekFirst moves cursor to the beginning
 func (it *Iterator) SeekFirst() {
+	it.check()
+	it.resetLimits()
 	it.iter.SeekFirst()
 	it.skipUnwanted()
 }
@@ -46,34 +93,83 @@ func (it *Iterator) SeekFirst() {
 // Seek to a specified key or the next bigger one if an item with key does not
 // exist.
 func (it *Iterator) Seek(bs []byte) {
+	it.check()
+	if db := it.snap.db; db.slowOps.Lookup > 0 {
+		defer db.logSlowOp("seek", time.Now(), db.slowOps.Lookup)
+	}
+
+	it.resetLimits()
 	itm := it.snap.db.newItem(bs, false)
 	it.iter.Seek(unsafe.Pointer(itm))
 	it.skipUnwanted()
 }
 
+// seekItem moves the cursor to the item or the next bigger one as per the
+// iterator comparator
+func (it *Iterator) seekItem(itm *Item) {
+	it.check()
+	it.iter.Seek(unsafe.Pointer(itm))
+	it.skipUnwanted()
+}
+
 // Valid eturns false when the iterator has reached the end.
 func (it *Iterator) Valid() bool {
-	return it.iter.Valid()
+	it.check()
+	return !it.truncated && it.iter.Valid()
+}
+
+// Truncated returns true if iteration was stopped by the iterator limits
+func (it *Iterator) Truncated() bool {
+	return it.truncated
 }
 
 // Get eturns the current item data from the iterator.
 func (it *Iterator) Get() []byte {
-	return (*Item)(it.iter.Get()).Bytes()
+	it.check()
+	itm := (*Item)(it.iter.Get())
+	it.checkItem(itm)
+	return itm.Bytes()
+}
+
+// GetMeta returns the metadata block of the current item
+func (it *Iterator) GetMeta() []byte {
+	it.check()
+	return it.snap.db.ItemMeta((*Item)(it.iter.Get()))
 }
 
 // GetNode eturns the current skiplist node which holds current item.
 func (it *Iterator) GetNode() *skiplist.Node {
+	it.check()
 	return it.iter.GetNode()
 }
 
 // Next moves iterator cursor to the next item
 func (it *Iterator) Next() {
+	it.check()
+	if it.limits != nil {
+		it.yielded++
+		it.bytes += int64((*Item)(it.iter.Get()).dataLen)
+	}
+
 	it.iter.Next()
 	it.count++
 	it.skipUnwanted()
+	if it.limits != nil && it.iter.Valid() {
+		it.truncated = it.limitReached()
+	}
 	if it.refreshRate > 0 && it.count > it.refreshRate {
 		it.Refresh()
 		it.count = 0
+		it.nextCheck = refreshCheckInterval
+	} else if it.refreshInterval > 0 && it.count >= it.nextCheck {
+		// Skipped items are counted too, so the count may step over a
+		// multiple of the check interval
+		it.nextCheck = it.count + refreshCheckInterval
+		if time.Since(it.lastRefresh) >= it.refreshInterval {
+			it.Refresh()
+			it.count = 0
+			it.nextCheck = refreshCheckInterval
+		}
 	}
 }
 
@@ -87,6 +183,10 @@ func (it *Iterator) Refresh() {
 		it.iter = it.snap.db.store.NewIterator(it.snap.db.iterCmp, it.buf)
 		it.iter.Seek(unsafe.Pointer(itm))
 	}
+
+	if it.refreshInterval > 0 {
+		it.lastRefresh = time.Now()
+	}
 }
 
 // SetRefreshRate sets automatic refresh frequency. By default, it is unlimited
@@ -96,8 +196,32 @@ func (it *Iterator) SetRefreshRate(rate int) {
 	it.refreshRate = rate
 }
 
+// SetLimits bounds the iteration performed after every Seek() or SeekFirst()
+func (it *Iterator) SetLimits(l IteratorLimits) {
+	it.limits = &l
+}
+
+func (it *Iterator) resetLimits() {
+	if it.limits != nil {
+		it.yielded = 0
+		it.bytes = 0
+		it.truncated = false
+		if it.limits.MaxDuration > 0 {
+			it.start = time.Now()
+		}
+	}
+}
+
+func (it *Iterator) limitReached() bool {
+	l := it.limits
+	return l.MaxItems > 0 && it.yielded >= l.MaxItems ||
+		l.MaxBytes > 0 && it.bytes >= l.MaxBytes ||
+		l.MaxDuration > 0 && time.Since(it.start) >= l.MaxDuration
+}
+
 // Close executes destructor for iterator
 func (it *Iterator) Close() {
+	it.markClosed()
 	it.snap.Close()
 	it.snap.db.store.FreeBuf(it.buf)
 	it.iter.Close()
@@ -109,9 +233,17 @@ func (m *Nitro) NewIterator(snap *Snapshot) *Iterator {
 		return nil
 	}
 	buf := snap.db.store.MakeBuf()
-	return &Iterator{
-		snap: snap,
-		iter: m.store.NewIterator(m.iterCmp, buf),
-		buf:  buf,
+	it := &Iterator{
+		snap:            snap,
+		iter:            m.store.NewIterator(m.iterCmp, buf),
+		buf:             buf,
+		refreshRate:     m.iterRefresh.Items,
+		refreshInterval: m.iterRefresh.Interval,
+	}
+
+	if it.refreshInterval > 0 {
+		it.lastRefresh = time.Now()
 	}
+
+	return it
 }
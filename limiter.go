@@ -0,0 +1,71 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by concurrent workers
+type rateLimiter struct {
+	sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	return &rateLimiter{
+		rate: float64(bytesPerSec),
+		last: time.Now(),
+	}
+}
+
+// wait consumes n tokens and blocks until the bucket is no longer in debt
+func (rl *rateLimiter) wait(n int64) {
+	rl.Lock()
+	now := time.Now()
+	rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+	// Allow a burst of upto a second worth of writes
+	if rl.tokens > rl.rate {
+		rl.tokens = rl.rate
+	}
+	rl.last = now
+	rl.tokens -= float64(n)
+	debt := rl.tokens
+	rl.Unlock()
+
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / rl.rate * float64(time.Second)))
+	}
+}
+
+// WriteLimiter throttles the insert throughput of the writers it is shared by
+type WriteLimiter struct {
+	rl *rateLimiter
+}
+
+// NewWriteLimiter creates a token bucket based limiter which allows upto
+// bytesPerSec bytes of item data to be inserted per second
+func NewWriteLimiter(bytesPerSec int64) *WriteLimiter {
+	return &WriteLimiter{rl: newRateLimiter(bytesPerSec)}
+}
+
+func (l *WriteLimiter) wait(n int64) {
+	l.rl.wait(n)
+}
+
+// SetWriteLimiter throttles the Put operations of the writer using the limiter.
+// Writers of the same tenant can share a limiter. A nil limiter removes the
+// throttling.
+func (w *Writer) SetWriteLimiter(l *WriteLimiter) {
+	w.limiter = l
+}
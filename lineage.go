@@ -0,0 +1,177 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const dumpMetaFile = "meta.json"
+
+// dumpVersion is the version of the backup format. All integers in backup
+// files have an explicit byte order, so that backups can be restored on
+// hosts of any architecture. The version should be bumped on incompatible
+// format changes, so that older versions reject the backup.
+const dumpVersion = 1
+
+// LineageEventType describes how a Nitro instance was derived from another
+type LineageEventType string
+
+const (
+	// LineageRestoredFrom means the instance was restored from a disk backup
+	LineageRestoredFrom LineageEventType = "restored-from"
+	// LineageRolledBackTo means the instance was rolled back to one of its
+	// snapshots
+	LineageRolledBackTo LineageEventType = "rolled-back-to"
+)
+
+// LineageEvent records one step in the derivation chain of a Nitro instance
+type LineageEvent struct {
+	Type LineageEventType `json:"type"`
+	UUID string           `json:"uuid"`
+	Time time.Time        `json:"time"`
+	// Snapshot number and label of the rollback target
+	Sn    uint32 `json:"sn,omitempty"`
+	Label string `json:"label,omitempty"`
+}
+
+// dumpMeta is the store level metadata persisted with a disk backup
+type dumpMeta struct {
+	Version int               `json:"version,omitempty"`
+	UUID    string            `json:"uuid"`
+	Lineage []LineageEvent    `json:"lineage,omitempty"`
+	Items   int64             `json:"items"`
+	Format  FileType          `json:"format,omitempty"`
+	Dirty   bool              `json:"dirty,omitempty"`
+	Meta    map[string][]byte `json:"meta,omitempty"`
+
+	SnapshotSn uint32    `json:"snapshot_sn,omitempty"`
+	Seqno      uint64    `json:"seqno,omitempty"`
+	Created    time.Time `json:"created"`
+	Label      string    `json:"label,omitempty"`
+}
+
+// BackupInfo describes a disk backup and the snapshot it was taken from
+type BackupInfo struct {
+	UUID  string
+	Items int64
+	// Dirty is true if the backup did not complete
+	Dirty bool
+
+	SnapshotSn uint32
+	Seqno      uint64
+	// Created is the creation time of the snapshot. It is zero for backups
+	// written by older versions.
+	Created time.Time
+	Label   string
+}
+
+// BackupInfo reads the description of a disk backup without restoring it.
+// It returns an os.IsNotExist() error if the directory has no backup
+// metadata.
+func (m *Nitro) BackupInfo(dir string) (BackupInfo, error) {
+	meta, err := m.readDumpMeta(dir)
+	if err != nil {
+		return BackupInfo{}, err
+	} else if meta == nil {
+		return BackupInfo{}, os.ErrNotExist
+	}
+
+	return BackupInfo{
+		UUID:       meta.UUID,
+		Items:      meta.Items,
+		Dirty:      meta.Dirty,
+		SnapshotSn: meta.SnapshotSn,
+		Seqno:      meta.Seqno,
+		Created:    meta.Created,
+		Label:      meta.Label,
+	}, nil
+}
+
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+
+	// RFC 4122 version 4
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// UUID returns the unique identifier assigned to the Nitro instance
+func (m *Nitro) UUID() string {
+	return m.uuid
+}
+
+// Lineage returns the chain of instances this Nitro instance was derived from.
+// The oldest ancestor comes first.
+func (m *Nitro) Lineage() []LineageEvent {
+	return append([]LineageEvent(nil), m.lineage...)
+}
+
+func (m *Nitro) writeDumpMeta(dir string, snap *Snapshot, label string, dirty bool) error {
+	meta := dumpMeta{
+		Version:    dumpVersion,
+		UUID:       m.uuid,
+		Lineage:    m.lineage,
+		Items:      snap.Count(),
+		Format:     m.fileType,
+		Dirty:      dirty,
+		Meta:       snap.meta,
+		SnapshotSn: snap.sn,
+		Seqno:      snap.seqno,
+		Created:    snap.created,
+		Label:      label,
+	}
+
+	bs, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(m.fs, filepath.Join(dir, dumpMetaFile), bs, 0660)
+}
+
+// readDumpMeta returns nil metadata for backups which were created without it
+func (m *Nitro) readDumpMeta(dir string) (*dumpMeta, error) {
+	bs, err := readFile(m.fs, filepath.Join(dir, dumpMetaFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	meta := new(dumpMeta)
+	if err := json.Unmarshal(bs, meta); err != nil {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+func (m *Nitro) restoreLineage(meta *dumpMeta) {
+	if meta == nil {
+		return
+	}
+
+	m.lineage = append(append([]LineageEvent(nil), meta.Lineage...), LineageEvent{
+		Type: LineageRestoredFrom,
+		UUID: meta.UUID,
+		Time: time.Now(),
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"io"
+	"path/filepath"
+)
+
+// ErrAlreadyOpen means the backup directory is in use by another process or
+// another Nitro operation
+var ErrAlreadyOpen = nerrors.New(nerrors.ErrBusy, "Backup directory is already in use")
+
+const dumpLockFile = "LOCK"
+
+// Locker is implemented by filesystems which support advisory locking.
+// StoreToDisk() holds an exclusive lock on the backup directory and
+// LoadFromDisk() holds a shared lock. LockFile should fail with ErrAlreadyOpen
+// instead of waiting for a conflicting lock.
+type Locker interface {
+	LockFile(name string, exclusive bool) (io.Closer, error)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func (m *Nitro) lockDumpDir(dir string, exclusive bool) (io.Closer, error) {
+	if l, ok := m.fs.(Locker); ok {
+		return l.LockFile(filepath.Join(dir, dumpLockFile), exclusive)
+	}
+
+	return nopCloser{}, nil
+}
+
+type memLock struct {
+	fs   *MemFileSystem
+	name string
+	excl bool
+}
+
+// LockFile acquires an in-memory advisory lock
+func (fs *MemFileSystem) LockFile(name string, exclusive bool) (io.Closer, error) {
+	name = filepath.Clean(name)
+
+	fs.Lock()
+	defer fs.Unlock()
+
+	if fs.locks == nil {
+		fs.locks = make(map[string]int)
+	}
+
+	// -1 is an exclusive lock and a positive count is shared locks
+	n := fs.locks[name]
+	if n < 0 || (exclusive && n > 0) {
+		return nil, ErrAlreadyOpen
+	}
+
+	if exclusive {
+		fs.locks[name] = -1
+	} else {
+		fs.locks[name] = n + 1
+	}
+
+	return &memLock{fs: fs, name: name, excl: exclusive}, nil
+}
+
+func (l *memLock) Close() error {
+	l.fs.Lock()
+	defer l.fs.Unlock()
+
+	if l.excl || l.fs.locks[l.name] == 1 {
+		delete(l.fs.locks, l.name)
+	} else {
+		l.fs.locks[l.name]--
+	}
+
+	return nil
+}
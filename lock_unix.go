@@ -0,0 +1,48 @@
+//go:build !windows
+// +build !windows
+
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// LockFile acquires an advisory flock on the named file. The file is created
+// for exclusive locks. A shared lock on a missing file always succeeds, so
+// that backups created before locking was introduced can be restored.
+func (OSFileSystem) LockFile(name string, exclusive bool) (io.Closer, error) {
+	flag, how := os.O_RDONLY, syscall.LOCK_SH
+	if exclusive {
+		flag, how = os.O_RDWR|os.O_CREATE, syscall.LOCK_EX
+	}
+
+	f, err := os.OpenFile(name, flag, 0660)
+	if err != nil {
+		if !exclusive && os.IsNotExist(err) {
+			return nopCloser{}, nil
+		}
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			return nil, ErrAlreadyOpen
+		}
+		return nil, err
+	}
+
+	// Closing the file releases the lock
+	return f, nil
+}
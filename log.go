@@ -0,0 +1,110 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// LogLevel describes severity of a log message
+type LogLevel int
+
+const (
+	// LogError - unrecoverable failures
+	LogError LogLevel = iota
+	// LogWarn - slow operations and recoverable failures
+	LogWarn
+	// LogInfo - lifecycle events
+	LogInfo
+	// LogDebug - verbose diagnostics
+	LogDebug
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogError:
+		return "Error"
+	case LogWarn:
+		return "Warn"
+	case LogInfo:
+		return "Info"
+	case LogDebug:
+		return "Debug"
+	}
+
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+// LogField is a structured key-value attached to a log message
+type LogField struct {
+	Key   string
+	Value interface{}
+}
+
+// Field creates a structured log field
+func Field(k string, v interface{}) LogField {
+	return LogField{Key: k, Value: v}
+}
+
+// Logger is the logging interface used by Nitro
+// Implementations should be safe for concurrent use.
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...LogField)
+}
+
+type stdLogger struct {
+	level LogLevel
+	l     *log.Logger
+}
+
+// NewStdLogger creates a Logger which writes messages upto the given level
+// in a key=value format
+func NewStdLogger(w io.Writer, level LogLevel) Logger {
+	return &stdLogger{
+		level: level,
+		l:     log.New(w, "", log.LstdFlags|log.Lmicroseconds),
+	}
+}
+
+func (sl *stdLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	if level > sl.level {
+		return
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "[nitro] [%s] %s", level, msg)
+	for _, f := range fields {
+		fmt.Fprintf(&buf, " %s=%v", f.Key, f.Value)
+	}
+	sl.l.Println(buf.String())
+}
+
+var defaultLogger = NewStdLogger(os.Stderr, LogWarn)
+
+// SlowOpThresholds configures the durations after which operations are
+// reported as slow. A zero threshold disables logging for the operation.
+type SlowOpThresholds struct {
+	Lookup time.Duration
+	Dump   time.Duration
+	GC     time.Duration
+}
+
+func (m *Nitro) logSlowOp(op string, t0 time.Time, threshold time.Duration, fields ...LogField) {
+	if dur := time.Since(t0); dur > threshold {
+		fields = append([]LogField{Field("op", op), Field("took", dur),
+			Field("threshold", threshold), Field("instance", m.uuid)}, fields...)
+		m.logger.Log(LogWarn, "slow operation", fields...)
+	}
+}
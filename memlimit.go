@@ -0,0 +1,150 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Files describing the cgroups of the process and the mounted cgroup
+// hierarchies
+var (
+	procSelfCgroup    = "/proc/self/cgroup"
+	procSelfMountinfo = "/proc/self/mountinfo"
+)
+
+// cgroup v1 reports a page aligned MaxInt64 when no limit is set
+const cgroupNoLimit = 1 << 62
+
+// MemoryLimit returns the memory limit of the cgroup that the process runs
+// in. The limits of the ancestor cgroups apply as well, so the lowest limit
+// along the path is returned. It returns 0 if there is no limit or it cannot
+// be detected, in which case the host memory is the only bound.
+func MemoryLimit() int64 {
+	dir, mount, file, ok := memoryCgroup()
+	if !ok {
+		return 0
+	}
+
+	var limit int64
+	for {
+		if l := readCgroupLimit(filepath.Join(dir, file)); l > 0 && (limit == 0 || l < limit) {
+			limit = l
+		}
+
+		if dir == mount || !strings.HasPrefix(dir, mount) {
+			return limit
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// memoryCgroup returns the directory of the memory cgroup of the process,
+// the mount point of its hierarchy and the name of the limit file. The
+// memory controller of cgroup v1 takes precedence on hybrid hosts.
+func memoryCgroup() (dir, mount, file string, ok bool) {
+	bs, err := ioutil.ReadFile(procSelfCgroup)
+	if err != nil {
+		return
+	}
+
+	// Lines are formatted as hierarchy-id:controllers:path
+	var v1Path, v2Path string
+	for _, line := range strings.Split(string(bs), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		if fields[0] == "0" && fields[1] == "" {
+			v2Path = fields[2]
+		} else if hasOption(fields[1], "memory") {
+			v1Path = fields[2]
+		}
+	}
+
+	bs, err = ioutil.ReadFile(procSelfMountinfo)
+	if err != nil {
+		return
+	}
+
+	// Lines are formatted as
+	// id parent major:minor root mount-point options [optional...] - type source super-options
+	for _, line := range strings.Split(string(bs), "\n") {
+		fields := strings.Fields(line)
+		sep := 0
+		for i, f := range fields {
+			if f == "-" {
+				sep = i
+				break
+			}
+		}
+
+		if sep < 6 || sep+3 >= len(fields) {
+			continue
+		}
+
+		root, point := fields[3], fields[4]
+		fstype, opts := fields[sep+1], fields[sep+3]
+		switch {
+		case v1Path != "" && fstype == "cgroup" && hasOption(opts, "memory"):
+			return cgroupDir(point, root, v1Path), point, "memory.limit_in_bytes", true
+		case v1Path == "" && v2Path != "" && fstype == "cgroup2":
+			return cgroupDir(point, root, v2Path), point, "memory.max", true
+		}
+	}
+
+	return
+}
+
+func hasOption(opts, name string) bool {
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == name {
+			return true
+		}
+	}
+	return false
+}
+
+// cgroupDir returns the directory of the cgroup path within the hierarchy
+// whose root directory is mounted at the mount point. The mount point is used
+// if the cgroup is outside of the mounted root, for example when the mount
+// belongs to another cgroup namespace.
+func cgroupDir(point, root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return point
+	}
+	return filepath.Join(point, rel)
+}
+
+// readCgroupLimit returns the limit in a memory.max or memory.limit_in_bytes
+// file or 0 if there is none
+func readCgroupLimit(file string) int64 {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0
+	}
+
+	s := strings.TrimSpace(string(bs))
+	if s == "max" {
+		return 0
+	}
+
+	limit, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || limit <= 0 || limit >= cgroupNoLimit {
+		return 0
+	}
+
+	return limit
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"container/heap"
+	"github.com/t3rm1n4l/nitro/skiplist"
+)
+
+// MergePolicy decides which items are returned by a MergeIterator when
+// several iterators have items with the same key
+type MergePolicy int
+
+const (
+	// MergeKeepAll returns every item, ordered by iterator position for
+	// equal keys
+	MergeKeepAll MergePolicy = iota
+	// MergeKeepFirst returns only the item of the first iterator
+	MergeKeepFirst
+	// MergeKeepLast returns only the item of the last iterator
+	MergeKeepLast
+)
+
+type mergeItem struct {
+	iter *Iterator
+	pos  int
+}
+
+type mergeHeap struct {
+	items  []mergeItem
+	cmp    KeyCompare
+	policy MergePolicy
+}
+
+func (h *mergeHeap) Len() int      { return len(h.items) }
+func (h *mergeHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *mergeHeap) Less(i, j int) bool {
+	x, y := h.items[i], h.items[j]
+	if c := h.cmp(x.iter.Get(), y.iter.Get()); c != 0 {
+		return c < 0
+	}
+
+	if h.policy == MergeKeepLast {
+		return x.pos > y.pos
+	}
+	return x.pos < y.pos
+}
+
+func (h *mergeHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(mergeItem))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+	n := len(h.items)
+	x := h.items[n-1]
+	h.items = h.items[:n-1]
+	return x
+}
+
+// MergeIterator combines the iterators of several snapshots into a single
+// ordered stream. The snapshots may belong to different Nitro instances,
+// for example the partitions of a store, but all of them should order items
+// using the key comparator of the first iterator.
+type MergeIterator struct {
+	iters []*Iterator
+	h     mergeHeap
+}
+
+// NewMergeIterator creates an iterator that merges the provided iterators.
+// The merge iterator owns the iterators and closes them on Close().
+func NewMergeIterator(iters []*Iterator, policy MergePolicy) *MergeIterator {
+	mit := &MergeIterator{
+		iters: iters,
+		h:     mergeHeap{policy: policy},
+	}
+
+	if len(iters) > 0 {
+		mit.h.cmp = iters[0].snap.db.keyCmp
+	}
+
+	return mit
+}
+
+func (mit *MergeIterator) init() {
+	mit.h.items = mit.h.items[:0]
+	for i, it := range mit.iters {
+		if it.Valid() {
+			mit.h.items = append(mit.h.items, mergeItem{iter: it, pos: i})
+		}
+	}
+	heap.Init(&mit.h)
+}
+
+// SeekFirst moves cursor to the first item
+func (mit *MergeIterator) SeekFirst() {
+	for _, it := range mit.iters {
+		it.SeekFirst()
+	}
+	mit.init()
+}
+
+// Seek moves cursor to the specified key or the next bigger one
+func (mit *MergeIterator) Seek(bs []byte) {
+	for _, it := range mit.iters {
+		it.Seek(bs)
+	}
+	mit.init()
+}
+
+// Valid returns false when cursor reaches end
+func (mit *MergeIterator) Valid() bool {
+	return mit.h.Len() > 0
+}
+
+// Get returns the current item data
+func (mit *MergeIterator) Get() []byte {
+	return mit.h.items[0].iter.Get()
+}
+
+// GetMeta returns the metadata block of the current item
+func (mit *MergeIterator) GetMeta() []byte {
+	return mit.h.items[0].iter.GetMeta()
+}
+
+// GetNode returns the skiplist node of the current item
+func (mit *MergeIterator) GetNode() *skiplist.Node {
+	return mit.h.items[0].iter.GetNode()
+}
+
+// Source returns the position of the iterator which provided the current item
+func (mit *MergeIterator) Source() int {
+	return mit.h.items[0].pos
+}
+
+// Next moves cursor to the next item
+func (mit *MergeIterator) Next() {
+	if !mit.Valid() {
+		return
+	}
+
+	top := heap.Pop(&mit.h).(mergeItem)
+	if mit.h.policy != MergeKeepAll {
+		// Skip the items with the same key before the current item is moved
+		for mit.h.Len() > 0 && mit.h.cmp(mit.h.items[0].iter.Get(), top.iter.Get()) == 0 {
+			dup := heap.Pop(&mit.h).(mergeItem)
+			mit.advance(dup)
+		}
+	}
+	mit.advance(top)
+}
+
+func (mit *MergeIterator) advance(x mergeItem) {
+	x.iter.Next()
+	if x.iter.Valid() {
+		heap.Push(&mit.h, x)
+	}
+}
+
+// Close closes the merged iterators
+func (mit *MergeIterator) Close() {
+	for _, it := range mit.iters {
+		it.Close()
+	}
+	mit.h.items = nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"github.com/t3rm1n4l/nitro/skiplist"
+	"sync/atomic"
+	"unsafe"
+)
+
+// In multiset mode, items with equal keys are ordered by their sequence
+// number. Lookup items have a zero sequence number, so that seeks position
+// before all items with equal keys.
+
+// UseMultiset allows multiple items with equal keys to coexist. Iterators
+// return equal items in insertion order. Delete() and GetNode() operate on
+// the oldest live item with the key.
+func (cfg *Config) UseMultiset() {
+	cfg.multiset = true
+}
+
+func (m *Nitro) newMultisetInsertCompare() skiplist.CompareFn {
+	keyCmp := m.keyCmp
+	return func(this, that unsafe.Pointer) int {
+		thisItem := (*Item)(this)
+		thatItem := (*Item)(that)
+		if v := keyCmp(thisItem.Bytes(), thatItem.Bytes()); v != 0 {
+			return v
+		}
+
+		s1, s2 := m.itemSeq(thisItem), m.itemSeq(thatItem)
+		switch {
+		case s1 < s2:
+			return -1
+		case s1 > s2:
+			return 1
+		}
+		return 0
+	}
+}
+
+// getMultisetNode returns the oldest live item with the key
+func (w *Writer) getMultisetNode(bs []byte) *skiplist.Node {
+	iter := w.store.NewIterator(w.iterCmp, w.buf)
+	defer iter.Close()
+
+	x := w.newItem(bs, false)
+	for iter.Seek(unsafe.Pointer(x)); iter.Valid(); iter.Next() {
+		itm := (*Item)(iter.Get())
+		if w.keyCmp(itm.Bytes(), bs) != 0 {
+			break
+		}
+
+		if atomic.LoadUint32(&itm.deadSn) == 0 {
+			return iter.GetNode()
+		}
+	}
+
+	return nil
+}
@@ -11,29 +11,40 @@ package nitro
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
 	"github.com/t3rm1n4l/nitro/mm"
 	"github.com/t3rm1n4l/nitro/skiplist"
 	"io"
-	"io/ioutil"
 	"math"
 	"math/rand"
-	"os"
 	"path/filepath"
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 	"unsafe"
 )
 
 var (
 	// ErrMaxSnapshotsLimitReached means 32 bit integer overflow of snap number
-	ErrMaxSnapshotsLimitReached = fmt.Errorf("Maximum snapshots limit reached")
+	ErrMaxSnapshotsLimitReached = nerrors.New(nerrors.ErrQuota, "Maximum snapshots limit reached")
 	// ErrShutdown means an operation on a shutdown Nitro instance
-	ErrShutdown = fmt.Errorf("Nitro instance has been shutdown")
+	ErrShutdown = nerrors.New(nerrors.ErrClosed, "Nitro instance has been shutdown")
+	// ErrDirtyBackup means the disk backup was interrupted before completion
+	ErrDirtyBackup = nerrors.New(nerrors.ErrCorrupt, "Disk backup is incomplete")
+	// ErrUnsupportedBackup means the disk backup was written by a newer
+	// version with an incompatible format
+	ErrUnsupportedBackup = nerrors.New(nerrors.ErrInvalidConfig, "Disk backup format is not supported")
+	// ErrCorruptBackup means a verified restore found inconsistent data
+	ErrCorruptBackup = nerrors.New(nerrors.ErrCorrupt, "Disk backup is corrupt")
+	// ErrSnapshotClosed means an operation on a snapshot which was closed
+	ErrSnapshotClosed = nerrors.New(nerrors.ErrClosed, "Snapshot is closed")
 )
 
 // KeyCompare implements item data key comparator
@@ -90,6 +101,9 @@ func DefaultConfig() Config {
 	cfg.fileType = RawdbFile
 	cfg.useMemoryMgmt = false
 	cfg.refreshRate = defaultRefreshRate
+	cfg.logger = defaultLogger
+	cfg.fs = OSFileSystem{}
+	cfg.debugChecks = raceEnabled
 	return cfg
 }
 
@@ -166,6 +180,12 @@ type Writer struct {
 	slSts1, slSts2, slSts3 skiplist.Stats
 	resSts                 restoreStats
 	count                  int64
+	delta                  SnapshotDelta
+	limiter                *WriteLimiter
+	stalls                 writerStallStats
+	sampler                *keySampler
+	lastSeq                uint64
+	gcCount                int64
 
 	*Nitro
 }
@@ -195,20 +215,71 @@ func (w *Writer) doDeltaWrite(itm *Item) {
 }
 
 // Put implements insert of an item into Intro
-// Put fails if an item already exists
+// Put fails if an item already exists or the instance is read-only.
+// Use TryPut() to tell the failures apart.
 func (w *Writer) Put(bs []byte) {
 	w.Put2(bs)
 }
 
 // Put2 returns the skiplist node of the item if Put() succeeds
 func (w *Writer) Put2(bs []byte) (n *skiplist.Node) {
+	return w.put(bs, nil)
+}
+
+// PutWithMeta inserts an item along with its metadata block.
+// The metadata is truncated or zero padded to the size configured using
+// UseItemMetadata(). It returns the skiplist node of the item if Put() succeeds.
+func (w *Writer) PutWithMeta(bs, meta []byte) *skiplist.Node {
+	return w.put(bs, meta)
+}
+
+// TryPut is same as PutWithMeta(), but returns ErrReadOnly if the write is
+// rejected by a read-only instance. A nil node without an error means the
+// item already exists.
+func (w *Writer) TryPut(bs, meta []byte) (*skiplist.Node, error) {
+	return w.tryPut(bs, meta)
+}
+
+func (w *Writer) put(bs, meta []byte) *skiplist.Node {
+	n, _ := w.tryPut(bs, meta)
+	return n
+}
+
+func (w *Writer) tryPut(bs, meta []byte) (n *skiplist.Node, err error) {
 	var success bool
+	if w.ReadOnly() {
+		atomic.AddUint64(&w.stalls.rejects, 1)
+		return nil, ErrReadOnly
+	}
+
+	atomic.AddUint64(&w.stalls.puts, 1)
+	if w.sampler != nil {
+		w.sampleKey(bs)
+	}
+	if w.limiter != nil {
+		w.throttle(int64(len(bs) + len(meta)))
+	}
+
 	x := w.newItem(bs, w.useMemoryMgmt)
+	if w.metaSize > 0 {
+		buf := w.ItemMeta(x)
+		for i := copy(buf, meta); i < len(buf); i++ {
+			buf[i] = 0
+		}
+	}
+	seq := w.assignSeq(x)
 	x.bornSn = w.getCurrSn()
+	w.bloomAdd(x)
 	n, success = w.store.Insert2(unsafe.Pointer(x), w.insCmp, w.existCmp, w.buf,
 		w.rand.Float32, &w.slSts1)
 	if success {
+		if w.hindex != nil {
+			w.hindex.update(n)
+		}
+		w.lastSeq = seq
 		w.count++
+		w.delta.ItemsAdded++
+		w.delta.BytesAdded += int64(len(bs))
 	} else {
 		w.freeItem(x)
 	}
@@ -216,7 +287,8 @@ func (w *Writer) Put2(bs []byte) (n *skiplist.Node) {
 }
 
 // Delete an item
-// Delete always succeed if an item exists.
+// Delete always succeed if an item exists, unless the instance is read-only.
+// Use TryDelete() to detect rejected deletes.
 func (w *Writer) Delete(bs []byte) (success bool) {
 	_, success = w.Delete2(bs)
 	return
@@ -231,12 +303,39 @@ func (w *Writer) Delete2(bs []byte) (n *skiplist.Node, success bool) {
 	return nil, false
 }
 
+// TryDelete is same as Delete(), but returns ErrReadOnly if the delete is
+// rejected by a read-only instance
+func (w *Writer) TryDelete(bs []byte) (bool, error) {
+	if n := w.GetNode(bs); n != nil {
+		return w.tryDeleteNode(n)
+	}
+
+	return false, nil
+}
+
 // DeleteNode deletes an item by specifying its skiplist Node.
 // Using this API can avoid a O(logn) lookup during Delete().
-func (w *Writer) DeleteNode(x *skiplist.Node) (success bool) {
+func (w *Writer) DeleteNode(x *skiplist.Node) bool {
+	success, _ := w.tryDeleteNode(x)
+	return success
+}
+
+func (w *Writer) tryDeleteNode(x *skiplist.Node) (success bool, err error) {
+	if w.ReadOnly() {
+		atomic.AddUint64(&w.stalls.rejects, 1)
+		return false, ErrReadOnly
+	}
+
+	// The node may be freed as soon as it is unlinked
+	dataLen := int64((*Item)(x.Item()).dataLen)
 	defer func() {
 		if success {
+			if w.seqnos {
+				w.lastSeq = atomic.AddUint64(&w.seq, 1)
+			}
 			w.count--
+			w.delta.ItemsRemoved++
+			w.delta.BytesRemoved += dataLen
 		}
 	}()
 
@@ -244,6 +343,10 @@ func (w *Writer) DeleteNode(x *skiplist.Node) (success bool) {
 	sn := w.getCurrSn()
 	gotItem := (*Item)(x.Item())
 	if gotItem.bornSn == sn {
+		// The node can not be accessed once it is unlinked
+		if w.hindex != nil {
+			w.hindex.remove(x)
+		}
 		success = w.store.DeleteNode(x, w.insCmp, w.buf, &w.slSts1)
 
 		barrier := w.store.GetAccesBarrier()
@@ -253,6 +356,10 @@ func (w *Writer) DeleteNode(x *skiplist.Node) (success bool) {
 
 	success = atomic.CompareAndSwapUint32(&gotItem.deadSn, 0, sn)
 	if success {
+		if w.hindex != nil {
+			w.hindex.remove(x)
+		}
+		w.gcCount++
 		if w.gctail == nil {
 			w.gctail = x
 			w.gchead = w.gctail
@@ -267,6 +374,29 @@ func (w *Writer) DeleteNode(x *skiplist.Node) (success bool) {
 // GetNode implements lookup of an item and return its skiplist Node
 // This API enables to lookup an item without using a snapshot handle.
 func (w *Writer) GetNode(bs []byte) *skiplist.Node {
+	if w.slowOps.Lookup > 0 {
+		defer w.logSlowOp("lookup", time.Now(), w.slowOps.Lookup)
+	}
+
+	if !w.MayContain(bs) {
+		return nil
+	}
+
+	if w.hindex != nil {
+		// The node may be deleted and freed once the shard lock is released
+		barrier := w.store.GetAccesBarrier()
+		token := barrier.Acquire()
+		defer barrier.Release(token)
+		if n := w.hindex.get(bs); n != nil && atomic.LoadUint32(&(*Item)(n.Item()).deadSn) == 0 {
+			return n
+		}
+		return nil
+	}
+
+	if w.multiset {
+		return w.getMultisetNode(bs)
+	}
+
 	iter := w.store.NewIterator(w.iterCmp, w.buf)
 	defer iter.Close()
 
@@ -289,11 +419,38 @@ type Config struct {
 
 	refreshRate int
 	fileType    FileType
+	metaSize    int
+	multiset    bool
+	seqnos      bool
 
 	useMemoryMgmt bool
 	useDeltaFiles bool
 	mallocFun     skiplist.MallocFn
 	freeFun       skiplist.FreeFn
+
+	logger  Logger
+	slowOps SlowOpThresholds
+	fs      FileSystem
+
+	gcQueueSize int
+	gcBatchSize int
+
+	debugChecks bool
+
+	eventListener EventListener
+	restartLimit  int
+	panicWindow   time.Duration
+	panicPolicy   PanicPolicy
+
+	keySampling KeySampling
+	gcPacing    GCPacing
+	iterRefresh IteratorRefresh
+	bloomFilter BloomFilter
+	hashIndex   *HashIndex
+
+	workerPool     *WorkerPool
+	maxBackupFiles int
+	undeleteWindow time.Duration
 }
 
 // SetKeyComparator provides key comparator for the Nitro item data
@@ -321,6 +478,57 @@ func (cfg *Config) UseDeltaInterleaving() {
 	cfg.useDeltaFiles = true
 }
 
+// SetFileType configures the file format used for disk backups.
+// Backups record their format, so restore is not affected by this setting
+// except for backups created by older versions.
+func (cfg *Config) SetFileType(t FileType) {
+	cfg.fileType = t
+}
+
+// UseItemMetadata reserves a fixed size metadata block with every item.
+// Metadata is provided using Writer.PutWithMeta(), preserved by disk backups
+// and can be read using Iterator.GetMeta() or Nitro.ItemMeta().
+func (cfg *Config) UseItemMetadata(size int) {
+	cfg.metaSize = size
+}
+
+// SetLogger configures the logger used by the Nitro instance
+func (cfg *Config) SetLogger(l Logger) {
+	cfg.logger = l
+}
+
+// SetSlowOpThresholds enables logging of lookups, disk backups and garbage
+// collection passes which take longer than the provided thresholds
+func (cfg *Config) SetSlowOpThresholds(t SlowOpThresholds) {
+	cfg.slowOps = t
+}
+
+// SetFileSystem configures the filesystem used for disk backup and restore
+func (cfg *Config) SetFileSystem(fs FileSystem) {
+	cfg.fs = fs
+}
+
+// SetReclaimQueueSize configures the buffer size of the queues which pass
+// garbage lists to the gc and free workers
+func (cfg *Config) SetReclaimQueueSize(n int) {
+	cfg.gcQueueSize = n
+}
+
+// SetGCBatchSize configures the number of deleted items after which the gc
+// worker hands over the unlinked items for freeing. Smaller batches release
+// memory sooner at the cost of more access barrier sessions. By default, the
+// garbage of a snapshot is released as a single batch.
+func (cfg *Config) SetGCBatchSize(n int) {
+	cfg.gcBatchSize = n
+}
+
+func (cfg *Config) reclaimQueueSize() int {
+	if cfg.gcQueueSize > 0 {
+		return cfg.gcQueueSize
+	}
+	return gcchanBufSize
+}
+
 type restoreStats struct {
 	DeltaRestored      uint64
 	DeltaRestoreFailed uint64
@@ -329,6 +537,8 @@ type restoreStats struct {
 // Nitro instance
 type Nitro struct {
 	id           int
+	uuid         string
+	lineage      []LineageEvent
 	store        *skiplist.Skiplist
 	currSn       uint32
 	snapshots    *skiplist.Skiplist
@@ -342,6 +552,17 @@ type Nitro struct {
 	gcchan   chan *skiplist.Node
 	freechan chan *skiplist.Node
 
+	reclaim   reclaimState
+	health    healthState
+	resources resourceState
+	stalls    stallState
+	meta      metaState
+	gcPacer   *rateLimiter
+	bloom     *bloom
+	hindex    *hashIndex
+	readOnly  int32
+	seq       uint64
+
 	hasShutdown bool
 	shutdownWg1 sync.WaitGroup // GC workers and StoreToDisk task
 	shutdownWg2 sync.WaitGroup // Free workers
@@ -357,11 +578,36 @@ func NewWithConfig(cfg Config) *Nitro {
 		gcsnapshots: skiplist.New(),
 		currSn:      1,
 		Config:      cfg,
-		gcchan:      make(chan *skiplist.Node, gcchanBufSize),
+		gcchan:      make(chan *skiplist.Node, cfg.reclaimQueueSize()),
 		id:          int(atomic.AddInt64(&dbInstancesCount, 1)),
+		uuid:        newUUID(),
+	}
+
+	if m.logger == nil {
+		m.logger = defaultLogger
+	}
+
+	if m.fs == nil {
+		m.fs = OSFileSystem{}
 	}
 
-	m.freechan = make(chan *skiplist.Node, gcchanBufSize)
+	if m.multiset {
+		m.insCmp = m.newMultisetInsertCompare()
+		m.iterCmp = m.insCmp
+		m.existCmp = nil
+	}
+
+	m.freechan = make(chan *skiplist.Node, cfg.reclaimQueueSize())
+	m.stalls.lastTime = time.Now()
+	if cfg.gcPacing.ItemsPerSec > 0 {
+		m.gcPacer = newRateLimiter(cfg.gcPacing.ItemsPerSec)
+	}
+	if cfg.bloomFilter.Bits > 0 {
+		m.bloom = newBloom(cfg.bloomFilter)
+	}
+	if cfg.hashIndex != nil && !cfg.multiset {
+		m.hindex = m.newHashIndex(*cfg.hashIndex)
+	}
 	m.store = skiplist.NewWithConfig(m.newStoreConfig())
 	m.initSizeFuns()
 
@@ -398,7 +644,7 @@ func (m *Nitro) newBSDestructor() skiplist.BarrierSessionDestructor {
 func (m *Nitro) initSizeFuns() {
 	m.snapshots.SetItemSizeFunc(SnapshotSize)
 	m.gcsnapshots.SetItemSizeFunc(SnapshotSize)
-	m.store.SetItemSizeFunc(ItemSize)
+	m.store.SetItemSizeFunc(m.itemSizeFn())
 }
 
 // New creates a Nitro instance using default configuration
@@ -409,11 +655,28 @@ func New() *Nitro {
 // MemoryInUse returns total memory used by the Nitro instance.
 func (m *Nitro) MemoryInUse() int64 {
 	storeStats := m.aggrStoreStats()
-	return storeStats.Memory + m.snapshots.MemoryInUse() + m.gcsnapshots.MemoryInUse()
+	sz := storeStats.Memory + m.snapshots.MemoryInUse() + m.gcsnapshots.MemoryInUse()
+	if m.hindex != nil {
+		sz += m.hindex.memoryInUse()
+	}
+	return sz
 }
 
 // Close shuts down the nitro instance
 func (m *Nitro) Close() {
+	m.close(false)
+}
+
+// CloseFast shuts down the nitro instance without freeing the items. It is
+// meant for ephemeral instances which are discarded along with the process.
+// If memory management is enabled, the memory used by the items is leaked.
+// A disk backup which is in progress is aborted and remains marked as dirty,
+// so that it is rejected by LoadFromDisk().
+func (m *Nitro) CloseFast() {
+	m.close(true)
+}
+
+func (m *Nitro) close(fast bool) {
 	// Wait until all snapshot iterators have finished
 	for s := m.snapshots.GetStats(); int(s.NodeCount) != 0; s = m.snapshots.GetStats() {
 		time.Sleep(time.Millisecond)
@@ -431,6 +694,9 @@ func (m *Nitro) Close() {
 	buf := dbInstances.MakeBuf()
 	defer dbInstances.FreeBuf(buf)
 	dbInstances.Delete(unsafe.Pointer(m), CompareNitro, buf, &dbInstances.Stats)
+	if m.hindex != nil {
+		m.hindex.close()
+	}
 
 	if m.useMemoryMgmt {
 		buf := m.snapshots.MakeBuf()
@@ -440,6 +706,10 @@ func (m *Nitro) Close() {
 		close(m.freechan)
 		m.shutdownWg2.Wait()
 
+		if fast {
+			return
+		}
+
 		// Manually free up all nodes
 		iter := m.store.NewIterator(m.iterCmp, buf)
 		defer iter.Close()
@@ -475,6 +745,10 @@ func (m *Nitro) newWriter() *Writer {
 		Nitro: m,
 	}
 
+	if m.keySampling.Every > 0 {
+		w.sampler = newKeySampler(m.keySampling)
+	}
+
 	w.slSts1.IsLocal(true)
 	w.slSts2.IsLocal(true)
 	w.slSts3.IsLocal(true)
@@ -489,30 +763,61 @@ func (m *Nitro) NewWriter() *Writer {
 	w.dwrCtx.Init()
 
 	m.shutdownWg1.Add(1)
+	atomic.AddInt32(&m.health.gcWorkers, 1)
 	go m.collectionWorker(w)
 	if m.useMemoryMgmt {
 		m.shutdownWg2.Add(1)
+		atomic.AddInt32(&m.health.freeWorkers, 1)
 		go m.freeWorker(w)
 	}
 
 	return w
 }
 
+// SnapshotDelta describes the mutations applied since the previous snapshot
+type SnapshotDelta struct {
+	ItemsAdded   int64
+	ItemsRemoved int64
+	BytesAdded   int64
+	BytesRemoved int64
+}
+
+func (d *SnapshotDelta) merge(o *SnapshotDelta) {
+	d.ItemsAdded += o.ItemsAdded
+	d.ItemsRemoved += o.ItemsRemoved
+	d.BytesAdded += o.BytesAdded
+	d.BytesRemoved += o.BytesRemoved
+}
+
 // Snapshot describes Nitro immutable snapshot
 type Snapshot struct {
 	sn       uint32
 	refCount int32
 	db       *Nitro
 	count    int64
+	delta    SnapshotDelta
 
 	gclist *skiplist.Node
+	// Stack of the final Close() when debug checks are enabled
+	closedBy unsafe.Pointer
+
+	// Oldest snapshot number merged into a collapsed snapshot
+	baseSn uint32
+	// Number of items in the gclist
+	gcCount int64
+	// Store metadata as of the snapshot creation
+	meta map[string][]byte
+
+	created time.Time
+	seqno   uint64
+	label   string
 }
 
 // SnapshotSize returns the memory used by Nitro snapshot metadata
 func SnapshotSize(p unsafe.Pointer) int {
 	s := (*Snapshot)(p)
 	return int(unsafe.Sizeof(s.sn) + unsafe.Sizeof(s.refCount) + unsafe.Sizeof(s.db) +
-		unsafe.Sizeof(s.count) + unsafe.Sizeof(s.gclist))
+		unsafe.Sizeof(s.count) + unsafe.Sizeof(s.delta) + unsafe.Sizeof(s.gclist))
 }
 
 // Count returns the number of items in the Nitro snapshot
@@ -520,6 +825,31 @@ func (s Snapshot) Count() int64 {
 	return s.count
 }
 
+// Delta returns the items and data bytes added and removed between the
+// previous snapshot and this snapshot
+func (s Snapshot) Delta() SnapshotDelta {
+	return s.delta
+}
+
+// Created returns the creation time of the snapshot. A snapshot returned by
+// LoadFromDisk() reports the creation time of the snapshot it was backed up
+// from.
+func (s Snapshot) Created() time.Time {
+	return s.created
+}
+
+// Seqno returns the latest sequence number assigned before the snapshot was
+// created. It is 0 unless sequence numbers are enabled.
+func (s Snapshot) Seqno() uint64 {
+	return s.seqno
+}
+
+// Label returns the label given to the disk backup the snapshot was
+// restored from
+func (s Snapshot) Label() string {
+	return s.label
+}
+
 // Encode implements Binary encoder for snapshot metadata
 func (s *Snapshot) Encode(buf []byte, w io.Writer) error {
 	l := 4
@@ -560,7 +890,9 @@ func (s *Snapshot) Open() bool {
 // Once a thread has finished using a snapshot, it can be destroyed by calling
 // Close(). Internal garbage collector takes care of freeing the items.
 func (s *Snapshot) Close() {
-	newRefcount := atomic.AddInt32(&s.refCount, -1)
+	newRefcount := s.checkClose(func() int32 {
+		return atomic.AddInt32(&s.refCount, -1)
+	})
 	if newRefcount == 0 {
 		buf := s.db.snapshots.MakeBuf()
 		defer s.db.snapshots.FreeBuf(buf)
@@ -568,7 +900,9 @@ func (s *Snapshot) Close() {
 		// Move from live snapshot list to dead list
 		s.db.snapshots.Delete(unsafe.Pointer(s), CompareSnapshot, buf, &s.db.snapshots.Stats)
 		s.db.gcsnapshots.Insert(unsafe.Pointer(s), CompareSnapshot, buf, &s.db.gcsnapshots.Stats)
-		s.db.GC()
+		if !s.db.gcPacing.Manual {
+			s.db.GC()
+		}
 	}
 }
 
@@ -595,6 +929,8 @@ func (m *Nitro) NewSnapshot() (*Snapshot, error) {
 
 	// Stitch all local gclists from all writers to create snapshot gclist
 	var head, tail *skiplist.Node
+	var delta SnapshotDelta
+	var gcCount int64
 
 	for w := m.wlist; w != nil; w = w.next {
 		if tail == nil {
@@ -607,16 +943,22 @@ func (m *Nitro) NewSnapshot() (*Snapshot, error) {
 
 		w.gchead = nil
 		w.gctail = nil
+		gcCount += w.gcCount
+		w.gcCount = 0
 
 		// Update global stats
 		m.store.Stats.Merge(&w.slSts1)
 		atomic.AddInt64(&m.itemsCount, w.count)
 		w.count = 0
+		delta.merge(&w.delta)
+		w.delta = SnapshotDelta{}
 	}
 
-	snap := &Snapshot{db: m, sn: m.getCurrSn(), refCount: 1, count: m.ItemsCount()}
+	snap := &Snapshot{db: m, sn: m.getCurrSn(), refCount: 1, count: m.ItemsCount(), delta: delta,
+		meta: m.meta.get(), created: time.Now(), seqno: m.Seqno()}
 	m.snapshots.Insert(unsafe.Pointer(snap), CompareSnapshot, buf, &m.snapshots.Stats)
 	snap.gclist = head
+	snap.gcCount = gcCount
 	newSn := atomic.AddUint32(&m.currSn, 1)
 	if newSn == math.MaxUint32 {
 		return nil, ErrMaxSnapshotsLimitReached
@@ -631,9 +973,17 @@ func (m *Nitro) ItemsCount() int64 {
 }
 
 func (m *Nitro) collectionWorker(w *Writer) {
+	m.setWorkerLabels(workerGC)
+	defer m.shutdownWg1.Done()
+	defer atomic.AddInt32(&m.health.gcWorkers, -1)
+
+	m.superviseWorker(workerGC, func() { m.runCollection(w) })
+}
+
+func (m *Nitro) runCollection(w *Writer) {
+	sizeFn := m.itemSizeFn()
 	buf := m.store.MakeBuf()
 	defer m.store.FreeBuf(buf)
-	defer m.shutdownWg1.Done()
 
 	for {
 		select {
@@ -644,39 +994,78 @@ func (m *Nitro) collectionWorker(w *Writer) {
 				close(w.dwrCtx.closed)
 				return
 			}
-			for n := gclist; n != nil; n = n.GClink {
+			var count int
+			var deferred int64
+			t0 := time.Now()
+			head := gclist
+			barrier := m.store.GetAccesBarrier()
+			for n := gclist; n != nil; {
+				next := n.GClink
 				w.doDeltaWrite((*Item)(n.Item()))
 				m.store.DeleteNode(n, m.insCmp, buf, &w.slSts2)
+				count++
+				if m.gcPacer != nil && count%gcPaceBatch == 0 && !m.hasShutdown {
+					m.gcPacer.wait(gcPaceBatch)
+				}
+				if m.useMemoryMgmt {
+					deferred += int64(sizeFn(n.Item()))
+				}
+
+				// Release large garbage lists in batches for lower memory
+				// reclamation latency
+				if m.gcBatchSize > 0 && count%m.gcBatchSize == 0 && next != nil {
+					n.GClink = nil
+					m.reclaim.addDeferred(deferred)
+					deferred = 0
+					barrier.FlushSession(unsafe.Pointer(head))
+					head = next
+				}
+				n = next
 			}
 
-			m.store.Stats.Merge(&w.slSts2)
+			if m.slowOps.GC > 0 {
+				m.logSlowOp("gc", t0, m.slowOps.GC, Field("nodes", count))
+			}
 
-			barrier := m.store.GetAccesBarrier()
-			barrier.FlushSession(unsafe.Pointer(gclist))
+			m.store.Stats.Merge(&w.slSts2)
+			m.reclaim.addDeferred(deferred)
+			barrier.FlushSession(unsafe.Pointer(head))
+			atomic.StoreInt64(&m.health.lastGC, time.Now().UnixNano())
 		}
 	}
 }
 
 func (m *Nitro) freeWorker(w *Writer) {
+	m.setWorkerLabels(workerFree)
+	m.superviseWorker(workerFree, func() { m.runFree(w) })
+	atomic.AddInt32(&m.health.freeWorkers, -1)
+	m.shutdownWg2.Done()
+}
+
+func (m *Nitro) runFree(w *Writer) {
+	sizeFn := m.itemSizeFn()
 	for freelist := range m.freechan {
+		var freed int64
 		for n := freelist; n != nil; {
 			dnode := n
 			n = n.GClink
 
 			itm := (*Item)(dnode.Item())
+			freed += int64(sizeFn(dnode.Item()))
 			m.freeItem(itm)
 			m.store.FreeNode(dnode, &w.slSts3)
 		}
 
 		m.store.Stats.Merge(&w.slSts3)
+		atomic.AddInt64(&m.reclaim.deferredBytes, -freed)
 	}
-
-	m.shutdownWg2.Done()
 }
 
 // Invariant: Each snapshot n is dependent on snapshot n-1.
 // Unless snapshot n-1 is collected, snapshot n cannot be collected.
-func (m *Nitro) collectDead() {
+// A positive budget stops collection once the garbage lists handed over to
+// the gc workers exceed `budget` items. It returns the number of items queued.
+func (m *Nitro) collectDead(budget int) (queued int) {
 	buf1 := m.snapshots.MakeBuf()
 	buf2 := m.snapshots.MakeBuf()
 	defer m.snapshots.FreeBuf(buf1)
@@ -688,20 +1077,31 @@ func (m *Nitro) collectDead() {
 	for iter.SeekFirst(); iter.Valid(); iter.Next() {
 		node := iter.GetNode()
 		sn := (*Snapshot)(node.Item())
-		if sn.sn != m.lastGCSn+1 {
+		if sn.firstSn() != m.lastGCSn+1 {
+			return
+		}
+
+		if budget > 0 && queued > 0 && queued+int(sn.gcCount) > budget {
+			return
+		}
+
+		if m.retained(sn) {
 			return
 		}
+		queued += int(sn.gcCount)
 
 		m.lastGCSn = sn.sn
-		m.gcchan <- sn.gclist
+		m.sendGCList(sn.gclist)
 		m.gcsnapshots.DeleteNode(node, CompareSnapshot, buf2, &m.gcsnapshots.Stats)
 	}
+
+	return
 }
 
 // GC implements manual garbage collection of Nitro snapshots.
 func (m *Nitro) GC() {
 	if atomic.CompareAndSwapInt32(&m.isGCRunning, 0, 1) {
-		m.collectDead()
+		m.collectDead(0)
 		atomic.CompareAndSwapInt32(&m.isGCRunning, 1, 0)
 	}
 }
@@ -721,10 +1121,39 @@ func (m *Nitro) GetSnapshots() []*Snapshot {
 	return snaps
 }
 
+// GetSnapshotAsOf returns the newest live snapshot created at or before t.
+// The snapshot is opened on behalf of the caller, who should Close() it.
+// It returns nil if there is no such snapshot. Only the snapshots retained
+// by the application can be found.
+func (m *Nitro) GetSnapshotAsOf(t time.Time) *Snapshot {
+	snaps := m.GetSnapshots()
+	for {
+		var best *Snapshot
+		for _, snap := range snaps {
+			if snap != nil && !snap.created.After(t) &&
+				(best == nil || !snap.created.Before(best.created)) {
+				best = snap
+			}
+		}
+
+		if best == nil || best.Open() {
+			return best
+		}
+
+		// The snapshot was closed meanwhile
+		for i := range snaps {
+			if snaps[i] == best {
+				snaps[i] = nil
+			}
+		}
+	}
+}
+
 func (m *Nitro) ptrToItem(itmPtr unsafe.Pointer) *Item {
 	o := (*Item)(itmPtr)
 	itm := m.newItem(o.Bytes(), false)
 	*itm = *o
+	copy(m.itemMetaBlock(itm), m.itemMetaBlock(o))
 
 	return itm
 }
@@ -739,13 +1168,16 @@ func (m *Nitro) Visitor(snap *Snapshot, callb VisitorCallback, shards int, concu
 	wch := make(chan int, shards)
 
 	if snap == nil {
-		panic("snapshot cannot be nil")
+		return m.invariant("snapshot cannot be nil")
 	}
 
-	func() {
+	concurrency = m.acquireWorkers(concurrency)
+	defer m.releaseWorkers(concurrency)
+
+	err := func() error {
 		tmpIter := m.NewIterator(snap)
 		if tmpIter == nil {
-			panic("iterator cannot be nil")
+			return m.invariant("iterator cannot be nil")
 		}
 		defer tmpIter.Close()
 
@@ -767,15 +1199,23 @@ func (m *Nitro) Visitor(snap *Snapshot, callb VisitorCallback, shards int, concu
 			}
 		}
 		pivotItems = append(pivotItems, nil) // end item
+		return nil
 	}()
 
-	errors := make([]error, len(pivotItems)-1)
+	if err != nil {
+		return err
+	}
+
+	// A panic is recorded in the error slot of the worker after the shard errors
+	errors := make([]error, len(pivotItems)-1+concurrency)
 
 	// Run workers
 	for i := 0; i < concurrency; i++ {
 		wg.Add(1)
-		go func(wg *sync.WaitGroup) {
+		go func(wg *sync.WaitGroup, id int) {
 			defer wg.Done()
+			defer m.recoverTo(&errors[len(pivotItems)-1+id])
+			m.setWorkerLabels(workerVisit)
 
 			for shard := range wch {
 				startItem := pivotItems[shard]
@@ -783,7 +1223,8 @@ func (m *Nitro) Visitor(snap *Snapshot, callb VisitorCallback, shards int, concu
 
 				itr := m.NewIterator(snap)
 				if itr == nil {
-					panic("iterator cannot be nil")
+					errors[shard] = m.invariant("iterator cannot be nil")
+					return
 				}
 				defer itr.Close()
 
@@ -791,7 +1232,7 @@ func (m *Nitro) Visitor(snap *Snapshot, callb VisitorCallback, shards int, concu
 				if startItem == nil {
 					itr.SeekFirst()
 				} else {
-					itr.Seek(startItem.Bytes())
+					itr.seekItem(startItem)
 				}
 			loop:
 				for ; itr.Valid(); itr.Next() {
@@ -806,7 +1247,7 @@ func (m *Nitro) Visitor(snap *Snapshot, callb VisitorCallback, shards int, concu
 					}
 				}
 			}
-		}(&wg)
+		}(&wg, i)
 	}
 
 	// Provide work and wait
@@ -873,6 +1314,30 @@ func (m *Nitro) changeDeltaWrState(state int,
 // StoreToDisk backups Nitro snapshot to disk
 // Concurrent threads are used to perform backup and concurrency can be specified.
 func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback ItemCallback) (err error) {
+	return m.StoreToDiskWithOptions(dir, snap, concurr, DumpOptions{ItemCallback: itmCallback})
+}
+
+// StoreToDiskWithOptions is same as StoreToDisk(). Additionally, it allows
+// to throttle the backup and to monitor its progress.
+func (m *Nitro) StoreToDiskWithOptions(dir string, snap *Snapshot, concurr int, opts DumpOptions) (err error) {
+	return m.storeToDisk(dir, snap, concurr, opts, newDumpTracker(snap.Count(), &opts))
+}
+
+func (m *Nitro) storeToDisk(dir string, snap *Snapshot, concurr int, opts DumpOptions,
+	tracker *dumpTracker) (err error) {
+	defer func() {
+		if errors.Is(err, syscall.ENOSPC) {
+			err = nerrors.Wrap(nerrors.ErrNoSpace, err)
+		}
+		m.health.recordDump(err)
+	}()
+
+	itmCallback := opts.ItemCallback
+	defer tracker.runProgress(opts.Progress, opts.ProgressInterval)()
+
+	if m.slowOps.Dump > 0 {
+		defer m.logSlowOp("dump", time.Now(), m.slowOps.Dump, Field("dir", dir), Field("sn", snap.sn))
+	}
 
 	var snapClosed bool
 	defer func() {
@@ -887,18 +1352,25 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 	}
 
 	datadir := filepath.Join(dir, "data")
-	os.MkdirAll(datadir, 0755)
-	shards := runtime.NumCPU()
+	if err = m.fs.MkdirAll(datadir, 0755); err != nil {
+		return err
+	}
+
+	lock, err := m.lockDumpDir(dir, true)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	// Mark the backup as dirty until it is complete
+	if err = m.writeDumpMeta(dir, snap, opts.Label, true); err != nil {
+		return err
+	}
+	shards := m.backupShards()
 
 	writers := make([]FileWriter, shards)
 	files := make([]string, shards)
-	defer func() {
-		for _, w := range writers {
-			if w != nil {
-				w.Close()
-			}
-		}
-	}()
+	defer closeFileWriters(writers)
 
 	for shard := 0; shard < shards; shard++ {
 		w := m.newFileWriter(m.fileType)
@@ -913,19 +1385,19 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 	}
 
 	// Initialize and setup delta processing
+	var deltaWriters []FileWriter
+	var deltaFiles []string
+	var deltadir string
+	var deltaActive bool
 	if m.useDeltaFiles {
-		deltaWriters := make([]FileWriter, m.numWriters())
-		deltaFiles := make([]string, m.numWriters())
-		defer func() {
-			for _, w := range deltaWriters {
-				if w != nil {
-					w.Close()
-				}
-			}
-		}()
+		deltaWriters = make([]FileWriter, m.numWriters())
+		deltaFiles = make([]string, m.numWriters())
+		defer closeFileWriters(deltaWriters)
 
-		deltadir := filepath.Join(dir, "delta")
-		os.MkdirAll(deltadir, 0755)
+		deltadir = filepath.Join(dir, "delta")
+		if err = m.fs.MkdirAll(deltadir, 0755); err != nil {
+			return err
+		}
 		for id := 0; id < m.numWriters(); id++ {
 			dw := m.newFileWriter(m.fileType)
 			file := fmt.Sprintf("shard-%d", id)
@@ -940,6 +1412,14 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 		if err = m.changeDeltaWrState(dwStateInit, deltaWriters, snap); err != nil {
 			return err
 		}
+		deltaActive = true
+
+		// Delta writers should be terminated even if the backup failed
+		defer func() {
+			if deltaActive {
+				m.changeDeltaWrState(dwStateTerminate, nil, nil)
+			}
+		}()
 
 		// Create a placeholder snapshot object. We are decoupled from holding snapshot items
 		// The fakeSnap object is to use the same iterator without any special handling for
@@ -950,13 +1430,6 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 		fakeSnap := *snap
 		fakeSnap.refCount = 1
 		snap = &fakeSnap
-
-		defer func() {
-			if err = m.changeDeltaWrState(dwStateTerminate, nil, nil); err == nil {
-				bs, _ := json.Marshal(deltaFiles)
-				ioutil.WriteFile(filepath.Join(deltadir, "files.json"), bs, 0660)
-			}
-		}()
 	}
 
 	visitorCallback := func(itm *Item, shard int) error {
@@ -968,6 +1441,7 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 		if err := w.WriteItem(itm); err != nil {
 			return err
 		}
+		tracker.add(1, m.encodedSize(itm))
 
 		if itmCallback != nil {
 			itmCallback(&ItemEntry{itm: itm, n: nil})
@@ -976,9 +1450,50 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 		return nil
 	}
 
-	if err = m.Visitor(snap, visitorCallback, shards, concurr); err == nil {
-		bs, _ := json.Marshal(files)
-		ioutil.WriteFile(filepath.Join(datadir, "files.json"), bs, 0660)
+	if err = m.Visitor(snap, visitorCallback, shards, concurr); err != nil {
+		return err
+	}
+
+	// Writers flush buffered data and write their footers on close, which
+	// should succeed before the backup is marked clean
+	if err = closeFileWriters(writers); err != nil {
+		return err
+	}
+
+	if m.useDeltaFiles {
+		deltaActive = false
+		if err = m.changeDeltaWrState(dwStateTerminate, nil, nil); err != nil {
+			return err
+		}
+
+		if err = closeFileWriters(deltaWriters); err != nil {
+			return err
+		}
+
+		bs, _ := json.Marshal(deltaFiles)
+		if err = writeFile(m.fs, filepath.Join(deltadir, "files.json"), bs, 0660); err != nil {
+			return err
+		}
+	}
+
+	bs, _ := json.Marshal(files)
+	if err = writeFile(m.fs, filepath.Join(datadir, "files.json"), bs, 0660); err != nil {
+		return err
+	}
+
+	return m.writeDumpMeta(dir, snap, opts.Label, false)
+}
+
+// closeFileWriters closes the open writers and returns the first error
+func closeFileWriters(writers []FileWriter) error {
+	var err error
+	for i, w := range writers {
+		if w != nil {
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+			writers[i] = nil
+		}
 	}
 
 	return err
@@ -986,24 +1501,78 @@ func (m *Nitro) StoreToDisk(dir string, snap *Snapshot, concurr int, itmCallback
 
 // LoadFromDisk restores Nitro from a disk backup
 func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snapshot, error) {
+	return m.LoadFromDiskWithOptions(dir, concurr, LoadOptions{ItemCallback: callb})
+}
+
+// LoadFromDiskWithOptions is same as LoadFromDisk(). Additionally, it allows
+// to monitor progress of the restore and to cancel it.
+// If the restore fails, the Nitro instance should be closed and discarded.
+func (m *Nitro) LoadFromDiskWithOptions(dir string, concurr int, opts LoadOptions) (*Snapshot, error) {
+	snap, err := m.loadFromDisk(dir, concurr, opts)
+	m.health.recordRestore(err)
+	return snap, err
+}
+
+func (m *Nitro) loadFromDisk(dir string, concurr int, opts LoadOptions) (*Snapshot, error) {
 	var wg sync.WaitGroup
 	var files []string
 	var bs []byte
 	var err error
 	datadir := filepath.Join(dir, "data")
+	callb := opts.ItemCallback
 
-	if bs, err = ioutil.ReadFile(filepath.Join(datadir, "files.json")); err != nil {
+	concurr = m.acquireWorkers(concurr)
+	defer m.releaseWorkers(concurr)
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	lock, err := m.lockDumpDir(dir, false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+
+	meta, err := m.readDumpMeta(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta != nil && meta.Dirty {
+		return nil, ErrDirtyBackup
+	}
+
+	if meta != nil && meta.Version > dumpVersion {
+		return nil, ErrUnsupportedBackup
+	}
+
+	if bs, err = readFile(m.fs, filepath.Join(datadir, "files.json")); err != nil {
 		return nil, err
 	}
 	json.Unmarshal(bs, &files)
 
+	var total int64
+	fileType := m.fileType
+	if meta != nil {
+		total = meta.Items
+		if meta.Format != 0 {
+			fileType = meta.Format
+		}
+	}
+	tracker := newDumpTracker(total, &DumpOptions{})
+	defer tracker.runProgress(opts.dumpProgressCallback(), opts.ProgressInterval)()
+
 	var nodeCallb skiplist.NodeCallback
-	wchan := make(chan int)
+	wchan := make(chan int, len(files))
 	b := skiplist.NewBuilderWithConfig(m.newStoreConfig())
-	b.SetItemSizeFunc(ItemSize)
+	b.SetItemSizeFunc(m.itemSizeFn())
 	segments := make([]*skiplist.Segment, len(files))
 	readers := make([]FileReader, len(files))
-	errors := make([]error, len(files))
+	// A panic is recorded in the error slot of the worker after the shard errors
+	errors := make([]error, len(files)+concurr)
+	firsts := make([]*Item, len(files))
+	lasts := make([]*Item, len(files))
 
 	if callb != nil {
 		nodeCallb = func(n *skiplist.Node) {
@@ -1022,24 +1591,37 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 	for i, file := range files {
 		segments[i] = b.NewSegment()
 		segments[i].SetNodeCallback(nodeCallb)
-		r := m.newFileReader(m.fileType)
+		r := m.newFileReader(fileType)
 		datafile := filepath.Join(datadir, file)
 		if err := r.Open(datafile); err != nil {
 			return nil, err
 		}
 
+		if seeker, ok := r.(FileSeeker); ok && opts.StartItem != nil {
+			if err := seeker.Seek(opts.StartItem); err != nil {
+				return nil, err
+			}
+		}
+
 		readers[i] = r
 	}
 
 	for i := 0; i < concurr; i++ {
 		wg.Add(1)
-		go func(wg *sync.WaitGroup) {
+		go func(wg *sync.WaitGroup, id int) {
 			defer wg.Done()
+			defer m.recoverTo(&errors[len(files)+id])
+			m.setWorkerLabels(workerLoad)
 
 			for shard := range wchan {
 				r := readers[shard]
 			loop:
-				for {
+				for i := 1; ; i++ {
+					if i%loadCancelCheckInterval == 0 && ctx.Err() != nil {
+						errors[shard] = ctx.Err()
+						return
+					}
+
 					itm, err := r.ReadItem()
 					if err != nil {
 						errors[shard] = err
@@ -1049,10 +1631,35 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 					if itm == nil {
 						break loop
 					}
+
+					// Data files are sorted
+					if c := opts.checkRange(m, itm); c != 0 {
+						m.freeItem(itm)
+						if c > 0 {
+							break loop
+						}
+						continue
+					}
+
+					if opts.Verify {
+						if last := lasts[shard]; last != nil &&
+							m.iterCmp(unsafe.Pointer(last), unsafe.Pointer(itm)) >= 0 {
+							m.freeItem(itm)
+							errors[shard] = ErrCorruptBackup
+							return
+						}
+						if firsts[shard] == nil {
+							firsts[shard] = itm
+						}
+						lasts[shard] = itm
+					}
+
+					m.bloomAdd(itm)
 					segments[shard].Add(unsafe.Pointer(itm))
+					tracker.add(1, m.encodedSize(itm))
 				}
 			}
-		}(&wg)
+		}(&wg, i)
 	}
 
 	for i := range files {
@@ -1061,28 +1668,36 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 	close(wchan)
 	wg.Wait()
 
+	// Partially restored segments are also assembled so that Close() can
+	// free their items
+	m.store = b.Assemble(segments...)
+
 	for _, err := range errors {
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	m.store = b.Assemble(segments...)
+	if opts.Verify {
+		if err := m.verifyOrder(firsts, lasts); err != nil {
+			return nil, err
+		}
+	}
 
 	// Delta processing
 	if m.useDeltaFiles {
 		m.DeltaRestoreFailed = 0
 		m.DeltaRestored = 0
 
-		wchan := make(chan int)
 		deltadir := filepath.Join(dir, "delta")
 		var files []string
-		if bs, err := ioutil.ReadFile(filepath.Join(deltadir, "files.json")); err == nil {
+		if bs, err := readFile(m.fs, filepath.Join(deltadir, "files.json")); err == nil {
 			json.Unmarshal(bs, &files)
 		}
+		wchan := make(chan int, len(files))
 
 		readers := make([]FileReader, len(files))
-		errors := make([]error, len(files))
+		errors := make([]error, len(files)+concurr)
 		writers := make([]*Writer, concurr)
 
 		defer func() {
@@ -1094,7 +1709,7 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 		}()
 
 		for i, file := range files {
-			r := m.newFileReader(m.fileType)
+			r := m.newFileReader(fileType)
 			deltafile := filepath.Join(deltadir, file)
 			if err := r.Open(deltafile); err != nil {
 				return nil, err
@@ -1108,11 +1723,18 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 			wg.Add(1)
 			go func(wg *sync.WaitGroup, id int) {
 				defer wg.Done()
+				defer m.recoverTo(&errors[len(files)+id])
+				m.setWorkerLabels(workerDelta)
 
 				for shard := range wchan {
 					r := readers[shard]
 				loop:
-					for {
+					for i := 1; ; i++ {
+						if i%loadCancelCheckInterval == 0 && ctx.Err() != nil {
+							errors[shard] = ctx.Err()
+							return
+						}
+
 						itm, err := r.ReadItem()
 						if err != nil {
 							errors[shard] = err
@@ -1123,7 +1745,14 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 							break loop
 						}
 
+						if opts.checkRange(m, itm) != 0 {
+							m.freeItem(itm)
+							continue
+						}
+						tracker.add(1, m.encodedSize(itm))
+
 						w := writers[id]
+						m.bloomAdd(itm)
 						if n, success := w.store.Insert2(unsafe.Pointer(itm),
 							w.insCmp, w.existCmp, w.buf, w.rand.Float32, &w.slSts1); success {
 
@@ -1161,7 +1790,30 @@ func (m *Nitro) LoadFromDisk(dir string, concurr int, callb ItemCallback) (*Snap
 
 	stats := m.store.GetStats()
 	m.itemsCount = int64(stats.NodeCount)
-	return m.NewSnapshot()
+	if opts.Verify {
+		if err := m.verifyCount(meta, &opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if m.hindex != nil {
+		m.hindex.build(m)
+	}
+	m.restoreLineage(meta)
+	if meta != nil {
+		m.meta.Lock()
+		m.meta.kv = meta.Meta
+		m.meta.Unlock()
+	}
+
+	snap, err := m.NewSnapshot()
+	if err == nil && meta != nil {
+		snap.label = meta.Label
+		if !meta.Created.IsZero() {
+			snap.created = meta.Created
+		}
+	}
+	return snap, err
 }
 
 // DumpStats returns Nitro statistics
@@ -9,16 +9,26 @@
 
 package nitro
 
+import "bytes"
+import "context"
+import "errors"
+import "io"
+import "io/ioutil"
 import "fmt"
+import "strings"
 import "sync/atomic"
 import "os"
+import "path/filepath"
 import "testing"
 import "time"
+import "unsafe"
 import "math/rand"
 import "sync"
 import "runtime"
 import "encoding/binary"
 import "github.com/t3rm1n4l/nitro/mm"
+import nerrors "github.com/t3rm1n4l/nitro/errors"
+import "math"
 
 var testConf Config
 
@@ -735,3 +745,2673 @@ func TestCloseWithActiveIterators(t *testing.T) {
 	wg.Wait()
 
 }
+
+func TestDumpLineage(t *testing.T) {
+	os.RemoveAll("db.dump")
+	db := NewWithConfig(testConf)
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	srcUUID := db.UUID()
+	db.Close()
+
+	db = NewWithConfig(testConf)
+	defer db.Close()
+	if db.UUID() == srcUUID {
+		t.Errorf("Expected a new uuid for the restored instance")
+	}
+
+	snap, err := db.LoadFromDisk("db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	lineage := db.Lineage()
+	if len(lineage) != 1 || lineage[0].UUID != srcUUID || lineage[0].Type != LineageRestoredFrom {
+		t.Errorf("Unexpected lineage %v", lineage)
+	}
+}
+
+type testLogger struct {
+	sync.Mutex
+	ops map[string]int
+}
+
+func (l *testLogger) Log(level LogLevel, msg string, fields ...LogField) {
+	l.Lock()
+	defer l.Unlock()
+	for _, f := range fields {
+		if f.Key == "op" {
+			l.ops[f.Value.(string)]++
+		}
+	}
+}
+
+func TestSlowOpLogging(t *testing.T) {
+	logger := &testLogger{ops: make(map[string]int)}
+	conf := testConf
+	conf.SetLogger(logger)
+	conf.SetSlowOpThresholds(SlowOpThresholds{Lookup: time.Nanosecond})
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	w.Put([]byte("key"))
+	w.GetNode([]byte("key"))
+
+	logger.Lock()
+	defer logger.Unlock()
+	if logger.ops["lookup"] != 1 {
+		t.Errorf("Expected a slow lookup log, got %v", logger.ops)
+	}
+}
+
+func TestDebugDumpGoroutines(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+	db.NewWriter()
+
+	var buf bytes.Buffer
+	// Workers label themselves after they are scheduled
+	for i := 0; i < 100 && !strings.Contains(buf.String(), "free"); i++ {
+		time.Sleep(time.Millisecond)
+		buf.Reset()
+		if err := db.DebugDumpGoroutines(&buf); err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "collectionWorker") || !strings.Contains(out, "freeWorker") {
+		t.Errorf("Expected nitro workers in goroutine dump, got %s", out)
+	}
+}
+
+func TestSnapshotDelta(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap1, _ := db.NewSnapshot()
+	defer snap1.Close()
+
+	for i := 0; i < 40; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap2, _ := db.NewSnapshot()
+	defer snap2.Close()
+
+	exp1 := SnapshotDelta{ItemsAdded: 100, BytesAdded: 1000}
+	if d := snap1.Delta(); d != exp1 {
+		t.Errorf("Expected %+v, got %+v", exp1, d)
+	}
+
+	exp2 := SnapshotDelta{ItemsRemoved: 40, BytesRemoved: 400}
+	if d := snap2.Delta(); d != exp2 {
+		t.Errorf("Expected %+v, got %+v", exp2, d)
+	}
+}
+
+func TestItemMetadata(t *testing.T) {
+	os.RemoveAll("db.dump")
+	conf := testConf
+	conf.UseItemMetadata(8)
+	db := NewWithConfig(conf)
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		meta := make([]byte, 8)
+		binary.BigEndian.PutUint64(meta, uint64(i))
+		w.PutWithMeta([]byte(fmt.Sprintf("%010d", i)), meta)
+	}
+	w.Put([]byte(fmt.Sprintf("%010d", 1000)))
+
+	verify := func(snap *Snapshot) {
+		i := 0
+		itr := snap.NewIterator()
+		defer itr.Close()
+		for itr.SeekFirst(); itr.Valid(); itr.Next() {
+			exp := uint64(i)
+			if i == 1000 {
+				exp = 0
+			}
+			if got := binary.BigEndian.Uint64(itr.GetMeta()); got != exp {
+				t.Errorf("Expected meta %d, got %d", exp, got)
+			}
+			i++
+		}
+
+		if i != 1001 {
+			t.Errorf("Expected 1001 items, got %d", i)
+		}
+	}
+
+	snap, _ := db.NewSnapshot()
+	verify(snap)
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	snap, err := db.LoadFromDisk("db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+	verify(snap)
+}
+
+func TestStoreToDiskThrottled(t *testing.T) {
+	os.RemoveAll("db.dump")
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+
+	var last DumpProgress
+	var calls int
+	opts := DumpOptions{
+		RateLimit:        200000,
+		ProgressInterval: 100 * time.Millisecond,
+		Progress: func(p DumpProgress) {
+			calls++
+			last = p
+		},
+	}
+
+	// 10000 items * 12 bytes needs ~0.6s after the initial burst
+	t0 := time.Now()
+	if err := db.StoreToDiskWithOptions("db.dump", snap, 4, opts); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	dur := time.Since(t0)
+
+	if dur < 400*time.Millisecond {
+		t.Errorf("Expected throttled backup, took %v", dur)
+	}
+
+	if calls < 2 || last.ItemsDone != 10000 || last.ItemsTotal != 10000 || last.BytesWritten != 120000 {
+		t.Errorf("Unexpected progress %+v after %d calls", last, calls)
+	}
+}
+
+func TestLoadFromDiskProgressCancel(t *testing.T) {
+	os.RemoveAll("db.dump")
+	db := NewWithConfig(testConf)
+	w := db.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	db.Close()
+
+	var last LoadProgress
+	db = NewWithConfig(testConf)
+	opts := LoadOptions{Progress: func(p LoadProgress) { last = p }}
+	snap, err := db.LoadFromDiskWithOptions("db.dump", 4, opts)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	snap.Close()
+	db.Close()
+
+	if last.ItemsDone != 100000 || last.ItemsTotal != 100000 || last.BytesRead != 1200000 {
+		t.Errorf("Unexpected progress %+v", last)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	db = NewWithConfig(testConf)
+	defer db.Close()
+	if _, err := db.LoadFromDiskWithOptions("db.dump", 4, LoadOptions{Context: ctx}); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestBlockFileLoadRange(t *testing.T) {
+	os.RemoveAll("db.dump")
+	conf := testConf
+	conf.SetFileType(BlockdbFile)
+	db := NewWithConfig(conf)
+	w := db.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	db.Close()
+
+	// Restore picks the format from the backup
+	db = NewWithConfig(testConf)
+	snap, err := db.LoadFromDisk("db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	VerifyCount(snap, 100000, t)
+	snap.Close()
+	db.Close()
+
+	db = NewWithConfig(testConf)
+	defer db.Close()
+	opts := LoadOptions{
+		StartItem: []byte(fmt.Sprintf("%010d", 35000)),
+		EndItem:   []byte(fmt.Sprintf("%010d", 45000)),
+	}
+	snap, err = db.LoadFromDiskWithOptions("db.dump", 4, opts)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	i := 35000
+	itr := snap.NewIterator()
+	defer itr.Close()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if exp := fmt.Sprintf("%010d", i); string(itr.Get()) != exp {
+			t.Errorf("Expected %s, got %s", exp, string(itr.Get()))
+		}
+		i++
+	}
+
+	if i != 45000 {
+		t.Errorf("Expected to restore upto 45000, got %d", i)
+	}
+}
+
+func TestMemFileSystemDump(t *testing.T) {
+	fs := NewMemFileSystem()
+	for _, ft := range []FileType{RawdbFile, BlockdbFile} {
+		conf := testConf
+		conf.SetFileSystem(fs)
+		conf.SetFileType(ft)
+		db := NewWithConfig(conf)
+		w := db.NewWriter()
+		for i := 0; i < 50000; i++ {
+			w.Put([]byte(fmt.Sprintf("%010d", i)))
+		}
+		snap, _ := db.NewSnapshot()
+		if err := db.StoreToDisk("mem/db.dump", snap, 4, nil); err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+		db.Close()
+
+		db = NewWithConfig(conf)
+		snap, err := db.LoadFromDisk("mem/db.dump", 4, nil)
+		if err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+		VerifyCount(snap, 50000, t)
+		snap.Close()
+		db.Close()
+	}
+
+	if _, err := os.Stat("mem"); !os.IsNotExist(err) {
+		t.Errorf("Expected no files on disk")
+	}
+
+	if len(fs.Files()) == 0 {
+		t.Errorf("Expected backup files in memory filesystem")
+	}
+}
+
+type testBlobStore struct {
+	sync.Mutex
+	objects map[string][]byte
+	puts    int
+
+	// Uploads fail once the store holds limit objects
+	limit    int
+	partPuts int
+}
+
+func (s *testBlobStore) Put(name string, r io.Reader) error {
+	bs, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	// Fail every third upload to exercise part retries
+	if s.puts++; s.puts%3 == 0 {
+		return errors.New("upload failed")
+	}
+	if s.limit > 0 && len(s.objects) >= s.limit {
+		return errors.New("store is full")
+	}
+	if strings.Contains(name, "/part-") {
+		s.partPuts++
+	}
+	s.objects[name] = bs
+	return nil
+}
+
+func (s *testBlobStore) Get(name string) (io.ReadCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+	bs, ok := s.objects[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(bs)), nil
+}
+
+func (s *testBlobStore) List(prefix string) ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+	var names []string
+	for name := range s.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func TestBlobStoreDump(t *testing.T) {
+	store := &testBlobStore{objects: make(map[string][]byte)}
+	conf := testConf
+	conf.SetFileType(BlockdbFile)
+	conf.SetFileSystem(NewBlobFileSystem(store, BlobOptions{PartSize: 64 * 1024, RetryBackoff: time.Millisecond}))
+	db := NewWithConfig(conf)
+	w := db.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("backups/db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	opts := LoadOptions{StartItem: []byte(fmt.Sprintf("%010d", 50000))}
+	snap, err := db.LoadFromDiskWithOptions("backups/db.dump", 4, opts)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	VerifyCount(snap, 50000, t)
+	snap.Close()
+
+	delete(store.objects, "backups/db.dump/data/shard-0/part-000000")
+	db2 := NewWithConfig(conf)
+	defer db2.Close()
+	if _, err := db2.LoadFromDisk("backups/db.dump", 4, nil); err != errIncompleteBlob {
+		t.Errorf("Expected incomplete blob error, got %v", err)
+	}
+
+	// A file being rewritten can not be read
+	fs := conf.fs
+	name := "backups/db.dump/data/shard-1"
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0755)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	f.Write(make([]byte, 128*1024))
+	if _, err := fs.OpenFile(name, os.O_RDONLY, 0); err != errIncompleteBlob {
+		t.Errorf("Expected incomplete blob error, got %v", err)
+	}
+
+	f.Close()
+	if _, err := fs.OpenFile(name, os.O_RDONLY, 0); err != nil {
+		t.Errorf("Expected no error. got=%v", err)
+	}
+}
+
+func TestBlobStoreResume(t *testing.T) {
+	store := &testBlobStore{objects: make(map[string][]byte), limit: 20}
+	conf := testConf
+	conf.SetFileType(BlockdbFile)
+	conf.SetFileSystem(NewBlobFileSystem(store, BlobOptions{PartSize: 64 * 1024, RetryBackoff: time.Millisecond}))
+	db := NewWithConfig(conf)
+	defer db.Close()
+	w := db.NewWriter()
+	for i := 0; i < 100000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("backups/db.dump", snap, 4, nil); err == nil {
+		t.Fatalf("Expected the interrupted backup to fail")
+	}
+
+	store.Lock()
+	store.limit = 0
+	uploaded := store.partPuts
+	store.partPuts = 0
+	store.Unlock()
+
+	snap, _ = db.NewSnapshot()
+	if err := db.StoreToDisk("backups/db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	var parts int
+	for name := range store.objects {
+		if strings.Contains(name, "/part-") {
+			parts++
+		}
+	}
+
+	// Parts stored without an upload state record are uploaded again
+	if uploaded == 0 || store.partPuts >= parts {
+		t.Errorf("Expected the stored parts to be skipped, uploaded %d of %d parts",
+			store.partPuts, parts)
+	}
+
+	db2 := NewWithConfig(conf)
+	defer db2.Close()
+	snap, err := db2.LoadFromDisk("backups/db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	VerifyCount(snap, 100000, t)
+	snap.Close()
+}
+
+func TestReclaimStats(t *testing.T) {
+	conf := testConf
+	conf.SetGCBatchSize(100)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	snap.Close()
+
+	for i := 0; i < 10000; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ = db.NewSnapshot()
+	snap.Close()
+	snap, _ = db.NewSnapshot()
+	snap.Close()
+
+	var sts ReclaimStats
+	for i := 0; i < 1000; i++ {
+		sts = db.ReclaimStats()
+		if sts.SessionsClosed >= 100 && sts.SessionsFreed == sts.SessionsClosed && sts.DeferredBytes == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if sts.SessionsClosed < 100 || sts.SessionsFreed != sts.SessionsClosed {
+		t.Errorf("Expected batched sessions to be freed\n%s", sts)
+	}
+
+	if sts.DeferredBytes != 0 || sts.MaxDeferredBytes == 0 {
+		t.Errorf("Unexpected deferred bytes\n%s", sts)
+	}
+}
+
+func expectPanic(t *testing.T, msg string, fn func()) {
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(fmt.Sprint(r), msg) {
+			t.Errorf("Expected panic with %q, got %v", msg, r)
+		}
+	}()
+	fn()
+}
+
+func TestDebugChecks(t *testing.T) {
+	conf := testConf
+	conf.EnableDebugChecks(true)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap, _ := db.NewSnapshot()
+	itr := snap.NewIterator()
+	itr.SeekFirst()
+	itr.Close()
+	expectPanic(t, "used after Close", func() { itr.Next() })
+
+	snap.Close()
+	expectPanic(t, "closed more times than it was opened", snap.Close)
+}
+
+func TestDebugChecksConcurrentClose(t *testing.T) {
+	conf := testConf
+	conf.EnableDebugChecks(true)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	for i := 0; i < 100; i++ {
+		snap, _ := db.NewSnapshot()
+		for j := 0; j < 3; j++ {
+			snap.Open()
+		}
+
+		// One more Close than Open races with the final release
+		var wg sync.WaitGroup
+		var panics int32
+		for j := 0; j < 5; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() {
+					if recover() != nil {
+						atomic.AddInt32(&panics, 1)
+					}
+				}()
+				snap.Close()
+			}()
+		}
+		wg.Wait()
+
+		if panics != 1 {
+			t.Fatalf("Expected one close to panic, got %d", panics)
+		}
+	}
+}
+
+func TestWriteLimiter(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	l := NewWriteLimiter(10000)
+	w1 := db.NewWriter()
+	w2 := db.NewWriter()
+	w1.SetWriteLimiter(l)
+	w2.SetWriteLimiter(l)
+
+	t0 := time.Now()
+	for i := 0; i < 1000; i++ {
+		w1.Put([]byte(fmt.Sprintf("a%09d", i)))
+		w2.Put([]byte(fmt.Sprintf("b%09d", i)))
+	}
+
+	// 20000 bytes at 10000 bytes/sec
+	if d := time.Since(t0); d < time.Second {
+		t.Errorf("Expected writes to be throttled, took %v", d)
+	}
+
+	w3 := db.NewWriter()
+	t0 = time.Now()
+	for i := 0; i < 1000; i++ {
+		w3.Put([]byte(fmt.Sprintf("c%09d", i)))
+	}
+
+	if d := time.Since(t0); d > time.Second/2 {
+		t.Errorf("Expected unthrottled writer, took %v", d)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	os.RemoveAll("db.dump")
+	db := NewWithConfig(testConf)
+	w := db.NewWriter()
+	db.NewWriter()
+
+	if h := db.Health(); !h.Healthy || h.GCWorkers != 2 || h.FreeWorkers != 2 {
+		t.Errorf("Unexpected health status %+v", h)
+	}
+
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	db2 := NewWithConfig(testConf)
+	defer db2.Close()
+	if _, err := db2.LoadFromDisk("db.dump.missing", 4, nil); err == nil {
+		t.Errorf("Expected restore error")
+	}
+
+	if h := db.Health(); h.LastDump.IsZero() || h.DumpErrors != 0 {
+		t.Errorf("Unexpected health status %+v", h)
+	}
+
+	if h := db2.Health(); h.RestoreErrors != 1 || h.LastError == "" {
+		t.Errorf("Unexpected health status %+v", h)
+	}
+
+	db.Close()
+	if h := db.Health(); h.Healthy || h.GCWorkers != 0 || h.FreeWorkers != 0 {
+		t.Errorf("Unexpected health status after close %+v", h)
+	}
+}
+
+func TestWorkerPanicRecovery(t *testing.T) {
+	var mu sync.Mutex
+	var events []Event
+	var frees int32
+
+	conf := testConf
+	conf.UseMemoryMgmt(mm.Malloc, func(p unsafe.Pointer) {
+		if atomic.AddInt32(&frees, 1) <= 3 {
+			panic("injected free failure")
+		}
+		mm.Free(p)
+	})
+	conf.SetWorkerRestartLimit(3)
+	conf.SetLogger(NewStdLogger(ioutil.Discard, LogError))
+	conf.SetEventListener(func(e Event) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	})
+
+	db := NewWithConfig(conf)
+	defer db.Close()
+	w := db.NewWriter()
+	w.Put([]byte("live"))
+
+	// Items deleted in the same snapshot are released immediately
+	for i := 0; i < 10; i++ {
+		k := []byte(fmt.Sprintf("%010d", i))
+		w.Put(k)
+		w.Delete(k)
+	}
+
+	for i := 0; i < 200 && !db.ReadOnly(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !db.ReadOnly() {
+		t.Fatalf("Expected read-only mode after repeated worker panics")
+	}
+
+	if n := w.Put2([]byte("x")); n != nil {
+		t.Errorf("Expected write to be rejected in read-only mode")
+	}
+
+	if _, err := w.TryPut([]byte("x"), nil); err != ErrReadOnly {
+		t.Errorf("Expected read-only error, got %v", err)
+	}
+
+	if _, err := w.TryDelete([]byte("live")); err != ErrReadOnly {
+		t.Errorf("Expected read-only error, got %v", err)
+	}
+
+	if h := db.Health(); h.Healthy || h.WorkerPanics != 3 || h.FreeWorkers != 1 {
+		t.Errorf("Unexpected health status %+v", h)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var panics, readOnly int
+	for _, e := range events {
+		switch e.Type {
+		case EventWorkerPanic:
+			panics++
+			if e.Worker != workerFree || !strings.Contains(e.Err.Error(), "injected") {
+				t.Errorf("Unexpected event %+v", e)
+			}
+		case EventReadOnly:
+			readOnly++
+		}
+	}
+
+	if panics != 3 || readOnly != 1 {
+		t.Errorf("Unexpected events %v", events)
+	}
+}
+
+func TestWorkerPanicWindow(t *testing.T) {
+	var frees int32
+
+	conf := testConf
+	conf.UseMemoryMgmt(mm.Malloc, func(p unsafe.Pointer) {
+		if atomic.AddInt32(&frees, 1) <= 5 {
+			panic("injected free failure")
+		}
+		mm.Free(p)
+	})
+	conf.SetWorkerRestartLimit(3)
+	// Restarts back off longer than the window
+	conf.SetWorkerPanicWindow(time.Millisecond)
+	conf.SetLogger(NewStdLogger(ioutil.Discard, LogError))
+
+	db := NewWithConfig(conf)
+	defer db.Close()
+	w := db.NewWriter()
+	for i := 0; i < 10; i++ {
+		k := []byte(fmt.Sprintf("%010d", i))
+		w.Put(k)
+		w.Delete(k)
+	}
+
+	for i := 0; i < 200 && atomic.LoadInt32(&frees) <= 5; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if h := db.Health(); h.ReadOnly || h.WorkerPanics != 5 {
+		t.Errorf("Expected isolated panics to not switch to read-only %+v", h)
+	}
+
+	if n, err := w.TryPut([]byte("x"), nil); n == nil || err != nil {
+		t.Errorf("Expected write to succeed, got %v", err)
+	}
+}
+
+type sliceSource struct {
+	items [][]byte
+}
+
+func (s *sliceSource) Next() ([]byte, error) {
+	if len(s.items) == 0 {
+		return nil, nil
+	}
+
+	bs := s.items[0]
+	s.items = s.items[1:]
+	return bs, nil
+}
+
+func TestPanicPolicy(t *testing.T) {
+	conf := testConf
+	conf.SetPanicPolicy(ErrorOnViolation)
+	conf.SetLogger(NewStdLogger(ioutil.Discard, LogError))
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	if err := db.Visitor(nil, nil, 4, 4); err == nil {
+		t.Errorf("Expected invariant error for nil snapshot")
+	}
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+	callb := func(itm *Item, shard int) error {
+		if string(itm.Bytes()) == fmt.Sprintf("%010d", 5000) {
+			panic("callback failure")
+		}
+		return nil
+	}
+
+	err := db.Visitor(snap, callb, 4, 4)
+	if ierr, ok := err.(*InvariantError); !ok || ierr.Msg != "callback failure" {
+		t.Errorf("Expected invariant error, got %v", err)
+	}
+
+	// Panics before a worker takes its first shard are also reported
+	workerStartHook = func(worker string) {
+		if worker == workerVisit || worker == workerLoad {
+			panic("worker start failure")
+		}
+	}
+	defer func() { workerStartHook = nil }()
+
+	err = db.Visitor(snap, func(*Item, int) error { return nil }, 4, 4)
+	if ierr, ok := err.(*InvariantError); !ok || ierr.Msg != "worker start failure" {
+		t.Errorf("Expected invariant error, got %v", err)
+	}
+
+	db2 := NewWithConfig(conf)
+	defer db2.Close()
+	_, err = db2.BulkLoad([]ItemSource{&sliceSource{}}, 2, nil)
+	if ierr, ok := err.(*InvariantError); !ok || ierr.Msg != "worker start failure" {
+		t.Errorf("Expected invariant error, got %v", err)
+	}
+}
+
+func TestAutoSnapshotter(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	var snaps []*Snapshot
+	a := db.NewAutoSnapshotter(SnapshotPolicy{Mutations: 1000})
+	defer a.Close()
+	a.AddHook(func(s *Snapshot) {
+		snaps = append(snaps, s)
+	})
+
+	w := db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+		if i%100 == 99 {
+			if _, err := a.MaybeSnapshot(); err != nil {
+				t.Fatalf("Expected no error. got=%v", err)
+			}
+		}
+	}
+
+	if len(snaps) != 10 || a.Current() != snaps[9] || a.Current().Count() != 10000 {
+		t.Errorf("Expected 10 snapshots, got %d", len(snaps))
+	}
+
+	if snap, _ := a.MaybeSnapshot(); snap != nil {
+		t.Errorf("Expected no snapshot without mutations")
+	}
+}
+
+func TestMultisetBlockSeek(t *testing.T) {
+	saved := BlockFileBlockSize
+	BlockFileBlockSize = 256
+	defer func() { BlockFileBlockSize = saved }()
+
+	conf := testConf
+	conf.UseMultiset()
+	conf.SetFileType(BlockdbFile)
+	conf.SetFileSystem(NewMemFileSystem())
+	conf.SetMaxBackupFiles(1)
+	db := NewWithConfig(conf)
+	w := db.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.Put([]byte(fmt.Sprintf("%05d", i)))
+	}
+	// A run of duplicates which spans many blocks
+	for i := 0; i < 100; i++ {
+		w.Put([]byte("00050"))
+	}
+
+	snap, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("mem/db.dump", snap, 1, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	opts := LoadOptions{StartItem: []byte("00050")}
+	snap, err := db.LoadFromDiskWithOptions("mem/db.dump", 1, opts)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	var dups int
+	itr := snap.NewIterator()
+	defer itr.Close()
+	for itr.Seek([]byte("00050")); itr.Valid() && string(itr.Get()) == "00050"; itr.Next() {
+		dups++
+	}
+
+	if dups != 101 {
+		t.Errorf("Expected 101 duplicates, got %d", dups)
+	}
+	VerifyCount(snap, 150, t)
+}
+
+func TestMultiset(t *testing.T) {
+	os.RemoveAll("db.dump")
+	conf := testConf
+	conf.UseMultiset()
+	conf.UseItemMetadata(4)
+	db := NewWithConfig(conf)
+	w := db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		meta := make([]byte, 4)
+		binary.BigEndian.PutUint32(meta, uint32(i))
+		w.PutWithMeta([]byte(fmt.Sprintf("%05d", i%100)), meta)
+	}
+
+	// Removes the oldest item of the key
+	if !w.Delete([]byte("00007")) {
+		t.Errorf("Expected delete to succeed")
+	}
+
+	snap, _ := db.NewSnapshot()
+	VerifyCount(snap, 9999, t)
+	verify := func(snap *Snapshot) {
+		itr := snap.NewIterator()
+		defer itr.Close()
+		itr.SetRefreshRate(7)
+		i := 0
+		for itr.Seek([]byte("00007")); itr.Valid(); itr.Next() {
+			if string(itr.Get()) != "00007" {
+				break
+			}
+			// Equal items are in insertion order
+			if exp := uint32(107 + i*100); binary.BigEndian.Uint32(itr.GetMeta()) != exp {
+				t.Errorf("Expected %d, got %d", exp, binary.BigEndian.Uint32(itr.GetMeta()))
+			}
+			i++
+		}
+
+		if i != 99 {
+			t.Errorf("Expected 99 duplicates, got %d", i)
+		}
+	}
+	verify(snap)
+
+	if err := db.StoreToDisk("db.dump", snap, 8, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	snap.Close()
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	snap, err := db.LoadFromDisk("db.dump", 8, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	VerifyCount(snap, 9999, t)
+	verify(snap)
+	snap.Close()
+
+	w = db.NewWriter()
+	w.Put([]byte("00007"))
+	snap, _ = db.NewSnapshot()
+	defer snap.Close()
+	itr := snap.NewIterator()
+	defer itr.Close()
+	count := 0
+	for itr.Seek([]byte("00007")); itr.Valid() && string(itr.Get()) == "00007"; itr.Next() {
+		count++
+	}
+
+	if count != 100 || binary.BigEndian.Uint32(itr.GetMeta()) != 8 {
+		t.Errorf("Expected restored items before the new item, got %d", count)
+	}
+}
+
+func TestIteratorLimits(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+
+	itr := snap.NewIterator()
+	defer itr.Close()
+
+	count := func() (n int) {
+		for ; itr.Valid(); itr.Next() {
+			n++
+		}
+		return
+	}
+
+	itr.SetLimits(IteratorLimits{MaxItems: 100})
+	itr.SeekFirst()
+	if n := count(); n != 100 || !itr.Truncated() {
+		t.Errorf("Expected 100 items and truncation, got %d %v", n, itr.Truncated())
+	}
+
+	itr.Seek([]byte(fmt.Sprintf("%010d", 950)))
+	if n := count(); n != 50 || itr.Truncated() {
+		t.Errorf("Expected 50 items without truncation, got %d %v", n, itr.Truncated())
+	}
+
+	itr.SetLimits(IteratorLimits{MaxBytes: 105})
+	itr.SeekFirst()
+	if n := count(); n != 11 || !itr.Truncated() {
+		t.Errorf("Expected 11 items and truncation, got %d %v", n, itr.Truncated())
+	}
+
+	itr.SetLimits(IteratorLimits{MaxDuration: time.Millisecond})
+	itr.SeekFirst()
+	time.Sleep(2 * time.Millisecond)
+	itr.Next()
+	if itr.Valid() || !itr.Truncated() {
+		t.Errorf("Expected truncation after deadline")
+	}
+}
+
+func TestContinuationToken(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+
+	// Scan in pages using the continuation token
+	itr := snap.NewIterator()
+	itr.SeekFirst()
+	total := 0
+	for {
+		for n := 0; itr.Valid() && n < 300; itr.Next() {
+			if exp := fmt.Sprintf("%010d", total); string(itr.Get()) != exp {
+				t.Fatalf("Expected %s, got %s", exp, itr.Get())
+			}
+			n++
+			total++
+		}
+
+		tok := itr.Token()
+		itr.Close()
+		if tok == nil {
+			break
+		}
+
+		var err error
+		if itr, err = db.ResumeIterator(tok); err != nil {
+			t.Fatalf("Resume failed: %v", err)
+		}
+	}
+
+	if total != 1000 {
+		t.Errorf("Expected 1000 items, got %d", total)
+	}
+
+	// Resume against a newer snapshot once the original is closed
+	itr = snap.NewIterator()
+	itr.Seek([]byte(fmt.Sprintf("%010d", 500)))
+	tok := itr.Token()
+	itr.Close()
+	snap.Close()
+
+	snap2, _ := db.NewSnapshot()
+	defer snap2.Close()
+	itr, err := db.ResumeIterator(tok)
+	if err != nil {
+		t.Fatalf("Resume failed: %v", err)
+	}
+	if itr.snap != snap2 || string(itr.Get()) != fmt.Sprintf("%010d", 500) {
+		t.Errorf("Unexpected resume position %s", itr.Get())
+	}
+	itr.Close()
+
+	if _, err := db.ResumeIterator([]byte("x")); err != ErrInvalidToken {
+		t.Errorf("Expected invalid token error, got %v", err)
+	}
+}
+
+func TestLookupRange(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.Put([]byte(fmt.Sprintf("%03d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+
+	w.Delete([]byte("015"))
+	w.Put([]byte("015x"))
+
+	items := snap.LookupRange([]byte("010"), []byte("020"), 0)
+	if len(items) != 10 {
+		t.Fatalf("Expected 10 items, got %d", len(items))
+	}
+	for i, itm := range items {
+		if exp := fmt.Sprintf("%03d", 10+i); string(itm) != exp {
+			t.Errorf("Expected %s, got %s", exp, itm)
+		}
+	}
+
+	if items := snap.LookupRange(nil, nil, 5); len(items) != 5 || string(items[4]) != "004" {
+		t.Errorf("Unexpected limited range %s", items)
+	}
+
+	if items := snap.LookupRange([]byte("095"), nil, 0); len(items) != 5 {
+		t.Errorf("Expected 5 items, got %d", len(items))
+	}
+
+	if items := snap.LookupRange([]byte("5"), []byte("6"), 0); len(items) != 0 {
+		t.Errorf("Expected empty range, got %s", items)
+	}
+}
+
+func TestWriteStallReport(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	r := db.WriteStallReport()
+	if r.Puts != 1000 || r.Bottleneck != BottleneckNone {
+		t.Errorf("Unexpected report %s", r)
+	}
+
+	w.SetWriteLimiter(NewWriteLimiter(10000))
+	for i := 0; i < 2000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i+1000)))
+	}
+
+	r = db.WriteStallReport()
+	if r.Puts != 2000 || r.ThrottleWait == 0 || r.Bottleneck != BottleneckThrottle {
+		t.Errorf("Expected throttle bottleneck, got %s", r)
+	}
+
+	db.setReadOnly()
+	w.Put([]byte("x"))
+	if r = db.WriteStallReport(); r.ReadOnlyRejects != 1 || r.Bottleneck != BottleneckReadOnly {
+		t.Errorf("Expected read-only bottleneck, got %s", r)
+	}
+}
+
+func BenchmarkItemBytes(b *testing.B) {
+	db := New()
+	defer db.Close()
+	itm := db.newItem(make([]byte, 64), false)
+
+	// Zero copy view of the item data used by iterators and comparators
+	b.Run("view", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if len(itm.Bytes()) != 64 {
+				b.Fatal("invalid length")
+			}
+		}
+	})
+
+	b.Run("copy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if len(append([]byte(nil), itm.Bytes()...)) != 64 {
+				b.Fatal("invalid length")
+			}
+		}
+	})
+}
+
+type failingFileSystem struct {
+	FileSystem
+	fail string
+	// Fail closing the files instead of opening them
+	failClose bool
+}
+
+type failingCloseFile struct {
+	File
+}
+
+func (f failingCloseFile) Close() error {
+	f.File.Close()
+	return errors.New("close failed")
+}
+
+func (fs failingFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if !strings.Contains(name, fs.fail) {
+		return fs.FileSystem.OpenFile(name, flag, perm)
+	}
+
+	if !fs.failClose {
+		return nil, errors.New("open failed")
+	}
+
+	f, err := fs.FileSystem.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return failingCloseFile{f}, nil
+}
+
+func TestDirtyBackup(t *testing.T) {
+	memfs := NewMemFileSystem()
+	conf := testConf
+	conf.SetFileSystem(memfs)
+	db := NewWithConfig(conf)
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	// Interrupt a backup into the same directory while writing the file
+	// list or flushing a data file
+	for _, fs := range []FileSystem{
+		failingFileSystem{FileSystem: memfs, fail: "files.json"},
+		failingFileSystem{FileSystem: memfs, fail: "shard-0", failClose: true},
+	} {
+		db.fs = memfs
+		snap, _ := db.NewSnapshot()
+		if err := db.StoreToDisk("mem/db.dump", snap, 4, nil); err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+
+		db.fs = fs
+		snap, _ = db.NewSnapshot()
+		if err := db.StoreToDisk("mem/db.dump", snap, 4, nil); err == nil {
+			t.Fatalf("Expected backup to fail")
+		}
+
+		db2 := NewWithConfig(conf)
+		if _, err := db2.LoadFromDisk("mem/db.dump", 4, nil); err != ErrDirtyBackup {
+			t.Errorf("Expected dirty backup error, got %v", err)
+		}
+		db2.CloseFast()
+	}
+	db.CloseFast()
+}
+
+func TestDumpDirLock(t *testing.T) {
+	os.RemoveAll("db.dump")
+	defer os.RemoveAll("db.dump")
+	os.MkdirAll("db.dump", 0755)
+
+	for _, fs := range []FileSystem{OSFileSystem{}, NewMemFileSystem()} {
+		conf := testConf
+		conf.SetFileSystem(fs)
+		db := NewWithConfig(conf)
+		w := db.NewWriter()
+		for i := 0; i < 1000; i++ {
+			w.Put([]byte(fmt.Sprintf("%010d", i)))
+		}
+
+		// Simulate another process holding the backup directory
+		lock, err := fs.(Locker).LockFile(filepath.Join("db.dump", dumpLockFile), true)
+		if err != nil {
+			t.Fatalf("Unable to lock: %v", err)
+		}
+
+		snap, _ := db.NewSnapshot()
+		if err := db.StoreToDisk("db.dump", snap, 4, nil); err != ErrAlreadyOpen {
+			t.Errorf("Expected already open error, got %v", err)
+		}
+		lock.Close()
+
+		snap, _ = db.NewSnapshot()
+		if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+		db.Close()
+
+		// Concurrent restores share the directory
+		lock, _ = fs.(Locker).LockFile(filepath.Join("db.dump", dumpLockFile), false)
+		db = NewWithConfig(conf)
+		snap, err = db.LoadFromDisk("db.dump", 4, nil)
+		if err != nil {
+			t.Fatalf("Expected no error. got=%v", err)
+		}
+		VerifyCount(snap, 1000, t)
+		snap.Close()
+		db.Close()
+
+		if _, err := fs.(Locker).LockFile(filepath.Join("db.dump", dumpLockFile), true); err != ErrAlreadyOpen {
+			t.Errorf("Expected shared lock to block exclusive lock, got %v", err)
+		}
+		lock.Close()
+	}
+}
+
+func TestCollapseSnapshots(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	old, _ := db.NewSnapshot()
+
+	// Short lived items are created and deleted across many snapshots
+	for s := 0; s < 100; s++ {
+		for i := 0; i < 100; i++ {
+			w.Put([]byte(fmt.Sprintf("tmp-%03d-%03d", s, i)))
+		}
+		if s > 0 {
+			for i := 0; i < 100; i++ {
+				w.Delete([]byte(fmt.Sprintf("tmp-%03d-%03d", s-1, i)))
+			}
+		}
+		w.Delete([]byte(fmt.Sprintf("%010d", s)))
+		snap, _ := db.NewSnapshot()
+		snap.Close()
+	}
+	curr, _ := db.NewSnapshot()
+
+	if n := db.gcsnapshots.GetStats().NodeCount; n != 100 {
+		t.Fatalf("Expected 100 pending snapshots, got %d", n)
+	}
+
+	if n := db.CollapseSnapshots(); n != 99 {
+		t.Errorf("Expected 99 collapsed snapshots, got %d", n)
+	}
+
+	if n := db.gcsnapshots.GetStats().NodeCount; n != 1 {
+		t.Errorf("Expected 1 pending snapshot, got %d", n)
+	}
+
+	// Wait for the gc workers to unlink the reclaimed items
+	for i := 0; i < 100 && int(db.store.GetStats().NodeCount) != 1000+100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := db.store.GetStats().NodeCount; n != 1000+100 {
+		t.Errorf("Expected short lived items to be reclaimed, got %d nodes", n)
+	}
+
+	VerifyCount(old, 1000, t)
+	VerifyCount(curr, 1000, t)
+	old.Close()
+	curr.Close()
+
+	for i := 0; i < 100 && db.gcsnapshots.GetStats().NodeCount != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := db.gcsnapshots.GetStats().NodeCount; n != 0 {
+		t.Errorf("Expected all snapshots to be collected, got %d", n)
+	}
+}
+
+func TestKeySkewReport(t *testing.T) {
+	conf := testConf
+	conf.SetKeySampling(KeySampling{Every: 10, PrefixLen: 4})
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.Put([]byte(fmt.Sprintf("seq-%010d", i)))
+	}
+
+	r := db.KeySkewReport(3)
+	if r.Samples != 1000 || r.Increasing < 0.99 {
+		t.Errorf("Expected monotonic keys, got %s", r)
+	}
+
+	// Most keys share a hot prefix among many cold prefixes
+	w2 := db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		if i%3 != 0 {
+			w2.Put([]byte(fmt.Sprintf("hot-%010d", rand.Int())))
+		} else {
+			w2.Put([]byte(fmt.Sprintf("%04d-%010d", rand.Intn(1000), i)))
+		}
+	}
+
+	r = db.KeySkewReport(3)
+	if r.Samples != 2000 || len(r.HotPrefixes) != 3 {
+		t.Fatalf("Unexpected report %s", r)
+	}
+
+	if p := r.HotPrefixes[0]; string(p.Prefix) != "seq-" || p.Count != 1000 {
+		t.Errorf("Expected seq- prefix first, got %s", r)
+	}
+
+	if p := r.HotPrefixes[1]; string(p.Prefix) != "hot-" || p.Fraction < 0.2 {
+		t.Errorf("Expected hot- prefix second, got %s", r)
+	}
+}
+
+func TestSeqnos(t *testing.T) {
+	os.RemoveAll("db.dump")
+	defer os.RemoveAll("db.dump")
+
+	conf := testConf
+	conf.UseSeqnos()
+	conf.UseItemMetadata(4)
+	db := NewWithConfig(conf)
+
+	w := db.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.PutWithMeta([]byte(fmt.Sprintf("%010d", i)), []byte("meta"))
+		if w.LastSeqno() != uint64(i+1) {
+			t.Errorf("Expected seqno %d, got %d", i+1, w.LastSeqno())
+		}
+	}
+
+	w.Put([]byte(fmt.Sprintf("%010d", 0)))
+	if w.LastSeqno() != 100 {
+		t.Errorf("Failed put should not consume a seqno")
+	}
+
+	w.Delete([]byte(fmt.Sprintf("%010d", 50)))
+	if w.LastSeqno() <= 100 || w.LastSeqno() != db.Seqno() {
+		t.Errorf("Expected a new seqno for delete, got %d", w.LastSeqno())
+	}
+
+	snap, _ := db.NewSnapshot()
+	itr := snap.NewIterator()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		var i uint64
+		fmt.Sscanf(string(itr.Get()), "%d", &i)
+		if itr.Seqno() != i+1 || string(itr.GetMeta()) != "meta" {
+			t.Errorf("Unexpected seqno %d for item %d", itr.Seqno(), i)
+		}
+	}
+	itr.Close()
+
+	if err := db.StoreToDisk("db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	snap, err := db.LoadFromDisk("db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	if db.Seqno() != 100 {
+		t.Errorf("Expected restored seqno 100, got %d", db.Seqno())
+	}
+
+	w = db.NewWriter()
+	w.Put([]byte("new"))
+	if w.LastSeqno() != 101 {
+		t.Errorf("Expected seqno 101 after restore, got %d", w.LastSeqno())
+	}
+}
+
+func TestGCPacing(t *testing.T) {
+	conf := testConf
+	conf.SetGCPacing(GCPacing{Manual: true})
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	snap.Close()
+
+	for s := 0; s < 10; s++ {
+		for i := 0; i < 100; i++ {
+			w.Delete([]byte(fmt.Sprintf("%010d", s*100+i)))
+		}
+		snap, _ := db.NewSnapshot()
+		snap.Close()
+	}
+
+	if n := db.gcsnapshots.GetStats().NodeCount; n != 11 {
+		t.Fatalf("Expected 11 pending snapshots in manual mode, got %d", n)
+	}
+
+	if n := db.GCWithBudget(250); n != 200 {
+		t.Errorf("Expected 200 items queued, got %d", n)
+	}
+
+	if n := db.gcsnapshots.GetStats().NodeCount; n != 8 {
+		t.Errorf("Expected 8 pending snapshots, got %d", n)
+	}
+
+	db.GC()
+	if n := db.gcsnapshots.GetStats().NodeCount; n != 0 {
+		t.Errorf("Expected no pending snapshots, got %d", n)
+	}
+
+	// Items are unlinked at the paced rate
+	conf = testConf
+	conf.SetGCPacing(GCPacing{ItemsPerSec: 1000})
+	db2 := NewWithConfig(conf)
+	defer db2.Close()
+
+	w = db2.NewWriter()
+	for i := 0; i < 2000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ = db2.NewSnapshot()
+	snap.Close()
+	for i := 0; i < 2000; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	t0 := time.Now()
+	snap, _ = db2.NewSnapshot()
+	snap.Close()
+	for db2.store.GetStats().NodeCount != 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if dur := time.Since(t0); dur < 500*time.Millisecond {
+		t.Errorf("Expected paced collection, took %v", dur)
+	}
+}
+
+func TestSharedSnapshot(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("nitro-shm-%d", os.Getpid()))
+	defer os.Remove(path)
+
+	conf := testConf
+	conf.SetKeyComparator(CompareKV)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put(KVToBytes([]byte(fmt.Sprintf("key-%05d", i)), []byte(fmt.Sprintf("val-%d", i))))
+	}
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+
+	if err := db.ExportSharedSnapshot(path, snap); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	ss, err := OpenSharedSnapshot(path, CompareKV)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer ss.Close()
+
+	if ss.Count() != 1000 {
+		t.Errorf("Expected 1000 items, got %d", ss.Count())
+	}
+
+	itr := ss.NewIterator()
+	count := 0
+	for itr.Seek(KVToBytes([]byte("key-00500"), nil)); itr.Valid(); itr.Next() {
+		k, v := KVFromBytes(itr.Get())
+		if string(k) != fmt.Sprintf("key-%05d", 500+count) || string(v) != fmt.Sprintf("val-%d", 500+count) {
+			t.Errorf("Unexpected item %s=%s", k, v)
+		}
+		count++
+	}
+
+	if count != 500 {
+		t.Errorf("Expected 500 items, got %d", count)
+	}
+
+	ioutil.WriteFile(path+".bad", []byte("NITROSHM garbage garbage garbage"), 0644)
+	defer os.Remove(path + ".bad")
+	if _, err := OpenSharedSnapshot(path+".bad", nil); err != ErrCorruptShared {
+		t.Errorf("Expected corrupt file error, got %v", err)
+	}
+
+	snap2, _ := db.NewSnapshot()
+	snap2.Close()
+	if err := db.ExportSharedSnapshot(path, snap2); err != ErrSnapshotClosed {
+		t.Errorf("Expected closed snapshot error, got %v", err)
+	}
+}
+
+// sharedArray encodes an item array with a single item at the offset
+func sharedArray(off uint64, l uint32, size int) []byte {
+	data := make([]byte, size)
+	copy(data, shmMagic)
+	binary.LittleEndian.PutUint32(data[8:12], shmVersion)
+	binary.LittleEndian.PutUint64(data[16:24], 1)
+	binary.LittleEndian.PutUint64(data[24:32], off)
+	if off <= uint64(size)-4 {
+		binary.LittleEndian.PutUint32(data[off:], l)
+	}
+	return data
+}
+
+func TestSharedSnapshotCorruptOffsets(t *testing.T) {
+	cases := [][]byte{
+		sharedArray(math.MaxUint64-2, 0, 64),
+		sharedArray(math.MaxUint64, 0, 64),
+		sharedArray(62, 0, 64),
+		sharedArray(32, math.MaxUint32, 64),
+		sharedArray(32, 29, 64),
+	}
+
+	for i, data := range cases {
+		if _, err := newItemArray(data, nil); err != ErrCorruptShared {
+			t.Errorf("Case %d: expected corrupt error, got %v", i, err)
+		}
+	}
+
+	a, err := newItemArray(sharedArray(32, 28, 64), nil)
+	if err != nil || len(a.item(0)) != 28 {
+		t.Errorf("Expected a valid array, got %v", err)
+	}
+}
+
+func FuzzSharedSnapshot(f *testing.F) {
+	f.Add(sharedArray(32, 28, 64))
+	f.Add(sharedArray(math.MaxUint64-2, 0, 64))
+	f.Add(sharedArray(32, math.MaxUint32, 64))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		a, err := newItemArray(data, nil)
+		if err != nil {
+			return
+		}
+
+		it := &ArrayIterator{a: a}
+		for it.SeekFirst(); it.Valid(); it.Next() {
+			it.Get()
+		}
+		a.lookup([]byte("key"))
+	})
+}
+
+func TestMemoryLimit(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "nitro-cgroup")
+	defer os.RemoveAll(dir)
+
+	savedCgroup, savedMountinfo := procSelfCgroup, procSelfMountinfo
+	defer func() { procSelfCgroup, procSelfMountinfo = savedCgroup, savedMountinfo }()
+	procSelfCgroup = filepath.Join(dir, "cgroup")
+	procSelfMountinfo = filepath.Join(dir, "mountinfo")
+
+	if l := MemoryLimit(); l != 0 {
+		t.Errorf("Expected no limit without proc files, got %d", l)
+	}
+
+	// cgroup v2 with the process in a nested cgroup
+	v2 := filepath.Join(dir, "unified")
+	ioutil.WriteFile(procSelfCgroup, []byte("0::/app/worker\n"), 0644)
+	ioutil.WriteFile(procSelfMountinfo, []byte(
+		"30 24 0:26 / "+v2+" rw,nosuid - cgroup2 cgroup2 rw\n"), 0644)
+	os.MkdirAll(filepath.Join(v2, "app", "worker"), 0755)
+	ioutil.WriteFile(filepath.Join(v2, "memory.max"), []byte("1073741824\n"), 0644)
+	ioutil.WriteFile(filepath.Join(v2, "app", "worker", "memory.max"), []byte("max\n"), 0644)
+	if l := MemoryLimit(); l != 1<<30 {
+		t.Errorf("Expected the root v2 limit, got %d", l)
+	}
+
+	ioutil.WriteFile(filepath.Join(v2, "app", "memory.max"), []byte("536870912\n"), 0644)
+	if l := MemoryLimit(); l != 1<<29 {
+		t.Errorf("Expected the parent v2 limit, got %d", l)
+	}
+
+	ioutil.WriteFile(filepath.Join(v2, "app", "worker", "memory.max"), []byte("268435456\n"), 0644)
+	if l := MemoryLimit(); l != 1<<28 {
+		t.Errorf("Expected the process v2 limit, got %d", l)
+	}
+
+	// cgroup v1 memory controller on a hybrid host, with the hierarchy
+	// mounted from a container cgroup
+	v1 := filepath.Join(dir, "memory")
+	ioutil.WriteFile(procSelfCgroup, []byte(
+		"4:memory:/docker/c1/app\n3:cpu,cpuacct:/docker/c1\n0::/\n"), 0644)
+	ioutil.WriteFile(procSelfMountinfo, []byte(
+		"30 24 0:26 / "+v2+" rw,nosuid - cgroup2 cgroup2 rw\n"+
+			"36 32 0:32 /docker/c1 "+v1+" rw,relatime shared:9 - cgroup cgroup rw,memory\n"), 0644)
+	os.MkdirAll(filepath.Join(v1, "app"), 0755)
+	ioutil.WriteFile(filepath.Join(v1, "memory.limit_in_bytes"), []byte("9223372036854771712\n"), 0644)
+	if l := MemoryLimit(); l != 0 {
+		t.Errorf("Expected no limit for v1 default, got %d", l)
+	}
+
+	ioutil.WriteFile(filepath.Join(v1, "app", "memory.limit_in_bytes"), []byte("134217728\n"), 0644)
+	if l := MemoryLimit(); l != 1<<27 {
+		t.Errorf("Expected the process v1 limit, got %d", l)
+	}
+}
+
+func TestStoreMeta(t *testing.T) {
+	conf := testConf
+	conf.SetFileSystem(NewMemFileSystem())
+	db := NewWithConfig(conf)
+	w := db.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	db.SetMeta("schema", []byte("v1"))
+	db.SetMeta("cursor", []byte("100"))
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+
+	// Updates after the snapshot should not be persisted with it
+	db.SetMeta("schema", []byte("v2"))
+	db.SetMeta("cursor", nil)
+	if string(db.GetMeta("schema")) != "v2" || db.GetMeta("cursor") != nil {
+		t.Errorf("Unexpected metadata %s %s", db.GetMeta("schema"), db.GetMeta("cursor"))
+	}
+
+	if string(snap.GetMeta("schema")) != "v1" || string(snap.GetMeta("cursor")) != "100" {
+		t.Errorf("Unexpected snapshot metadata %s %s", snap.GetMeta("schema"), snap.GetMeta("cursor"))
+	}
+
+	if err := db.StoreToDisk("mem/db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	snap2, err := db.LoadFromDisk("mem/db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap2.Close()
+
+	if string(db.GetMeta("schema")) != "v1" || string(db.GetMeta("cursor")) != "100" {
+		t.Errorf("Unexpected restored metadata %s %s", db.GetMeta("schema"), db.GetMeta("cursor"))
+	}
+}
+
+func TestCompareSnapshots(t *testing.T) {
+	conf := testConf
+	conf.SetKeyComparator(CompareKV)
+	db1 := NewWithConfig(conf)
+	defer db1.Close()
+	db2 := NewWithConfig(conf)
+	defer db2.Close()
+
+	w1 := db1.NewWriter()
+	w2 := db2.NewWriter()
+	for i := 0; i < 1000; i++ {
+		itm := KVToBytes([]byte(fmt.Sprintf("key-%05d", i)), []byte("v1"))
+		w1.Put(itm)
+		w2.Put(itm)
+	}
+
+	snap1, _ := db1.NewSnapshot()
+	defer snap1.Close()
+	snap2, _ := db2.NewSnapshot()
+	if r, err := Compare(snap1, snap2, nil); err != nil || !r.Equal() || r.Unchanged != 1000 {
+		t.Errorf("Expected identical snapshots, got %+v", r)
+	}
+	snap2.Close()
+
+	if _, err := Compare(snap1, snap2, nil); err != ErrSnapshotClosed {
+		t.Errorf("Expected closed snapshot error, got %v", err)
+	}
+
+	w2.Delete(KVToBytes([]byte("key-00000"), nil))
+	w2.Delete(KVToBytes([]byte("key-00500"), nil))
+	w2.Delete(KVToBytes([]byte("key-00700"), nil))
+	w2.Put(KVToBytes([]byte("key-00700"), []byte("v2")))
+	w2.Put(KVToBytes([]byte("key-99999"), []byte("v1")))
+	snap2, _ = db2.NewSnapshot()
+	defer snap2.Close()
+
+	var diffs []string
+	r, _ := Compare(snap1, snap2, func(dt DiffType, a, b []byte) {
+		var k []byte
+		if a != nil {
+			k, _ = KVFromBytes(a)
+		} else {
+			k, _ = KVFromBytes(b)
+		}
+		diffs = append(diffs, fmt.Sprintf("%s %s", dt, k))
+	})
+
+	exp := CompareResult{Added: 1, Removed: 2, Changed: 1, Unchanged: 997}
+	if r != exp {
+		t.Errorf("Expected %+v, got %+v", exp, r)
+	}
+
+	expDiffs := "removed key-00000,removed key-00500,changed key-00700,added key-99999"
+	if strings.Join(diffs, ",") != expDiffs {
+		t.Errorf("Unexpected diffs %v", diffs)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	conf := testConf
+	conf.SetKeyComparator(CompareKV)
+	conf.SetFileSystem(NewMemFileSystem())
+	conf.UseBloomFilter(BloomFilter{
+		Bits: 10000 * 10,
+		Key:  func(bs []byte) []byte { k, _ := KVFromBytes(bs); return k },
+	})
+	db := NewWithConfig(conf)
+
+	w := db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.Put(KVToBytes([]byte(fmt.Sprintf("key-%05d", i)), []byte("val")))
+	}
+
+	for i := 0; i < 10000; i++ {
+		if w.GetNode(KVToBytes([]byte(fmt.Sprintf("key-%05d", i)), nil)) == nil {
+			t.Fatalf("Expected key-%05d to be found", i)
+		}
+	}
+
+	for i := 0; i < 10000; i++ {
+		if w.GetNode(KVToBytes([]byte(fmt.Sprintf("absent-%05d", i)), nil)) != nil {
+			t.Fatalf("Unexpected absent-%05d", i)
+		}
+	}
+
+	sts := db.BloomStats()
+	if sts.Lookups != 20000 || sts.Negatives < 9500 {
+		t.Errorf("Unexpected bloom stats %+v", sts)
+	}
+
+	// The filter is rebuilt on restore
+	snap, _ := db.NewSnapshot()
+	if err := db.StoreToDisk("mem/db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	snap.Close()
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	snap, err := db.LoadFromDisk("mem/db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	for i := 0; i < 10000; i++ {
+		if !db.MayContain(KVToBytes([]byte(fmt.Sprintf("key-%05d", i)), nil)) {
+			t.Fatalf("Expected key-%05d in the restored filter", i)
+		}
+	}
+}
+
+func TestHashIndex(t *testing.T) {
+	conf := testConf
+	conf.SetKeyComparator(CompareKV)
+	conf.SetFileSystem(NewMemFileSystem())
+	conf.UseHashIndex(HashIndex{
+		Key: func(bs []byte) []byte { k, _ := KVFromBytes(bs); return k },
+	})
+	db := NewWithConfig(conf)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(id int, w *Writer) {
+			defer wg.Done()
+			for j := id; j < 10000; j += 4 {
+				w.Put(KVToBytes([]byte(fmt.Sprintf("key-%05d", j)), []byte("v1")))
+			}
+		}(i, db.NewWriter())
+	}
+	wg.Wait()
+
+	w := db.NewWriter()
+	snap, _ := db.NewSnapshot()
+	for i := 0; i < 10000; i += 2 {
+		if !w.Delete(KVToBytes([]byte(fmt.Sprintf("key-%05d", i)), nil)) {
+			t.Fatalf("Expected delete of key-%05d to succeed", i)
+		}
+	}
+	w.Put(KVToBytes([]byte("key-00000"), []byte("v2")))
+
+	for i := 0; i < 10000; i++ {
+		n := w.GetNode(KVToBytes([]byte(fmt.Sprintf("key-%05d", i)), nil))
+		if (i%2 == 0 && i != 0) != (n == nil) {
+			t.Fatalf("Unexpected lookup result for key-%05d", i)
+		}
+	}
+
+	n := w.GetNode(KVToBytes([]byte("key-00000"), nil))
+	if _, v := KVFromBytes((*Item)(n.Item()).Bytes()); string(v) != "v2" {
+		t.Errorf("Expected the new version, got %s", v)
+	}
+
+	// The deleted items are still visible to the older snapshot
+	if c := CountItems(snap); c != 10000 {
+		t.Errorf("Expected 10000 items in the snapshot, got %d", c)
+	}
+	snap.Close()
+
+	snap, _ = db.NewSnapshot()
+	if err := db.StoreToDisk("mem/db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	snap, err := db.LoadFromDisk("mem/db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	w = db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		n := w.GetNode(KVToBytes([]byte(fmt.Sprintf("key-%05d", i)), nil))
+		if (i%2 == 0 && i != 0) != (n == nil) {
+			t.Fatalf("Unexpected lookup result for restored key-%05d", i)
+		}
+	}
+}
+
+func TestIteratorRefreshConfig(t *testing.T) {
+	conf := testConf
+	conf.SetIteratorRefresh(IteratorRefresh{Items: 100, Interval: time.Millisecond})
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+
+	for i := 0; i < 10000; i += 2 {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap2, _ := db.NewSnapshot()
+	snap2.Close()
+
+	itr := snap.NewIterator()
+	defer itr.Close()
+	if itr.refreshRate != 100 || itr.refreshInterval != time.Millisecond {
+		t.Errorf("Expected iterator refresh config to be applied")
+	}
+
+	i := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if string(itr.Get()) != fmt.Sprintf("%010d", i) {
+			t.Fatalf("Unexpected item %s, expected %010d", itr.Get(), i)
+		}
+		if i%1000 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		i++
+	}
+
+	if i != 10000 {
+		t.Errorf("Expected 10000 items, got %d", i)
+	}
+
+	// Every Next() skips a deleted item, which keeps the item count odd
+	snap3, _ := db.NewSnapshot()
+	defer snap3.Close()
+	it2 := snap3.NewIterator()
+	defer it2.Close()
+	it2.SetRefreshRate(0)
+	it2.SeekFirst()
+	t0 := it2.lastRefresh
+	for i = 0; it2.Valid(); it2.Next() {
+		if i++; i%1000 == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if !it2.lastRefresh.After(t0) {
+		t.Errorf("Expected the refresh interval to trigger refreshes")
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	pool := NewWorkerPool(2)
+	conf := testConf
+	conf.SetFileSystem(NewMemFileSystem())
+	conf.SetWorkerPool(pool)
+	conf.SetMaxBackupFiles(2)
+
+	db1 := NewWithConfig(conf)
+	defer db1.Close()
+	db2 := NewWithConfig(conf)
+	defer db2.Close()
+
+	for _, db := range []*Nitro{db1, db2} {
+		w := db.NewWriter()
+		for i := 0; i < 10000; i++ {
+			w.Put([]byte(fmt.Sprintf("%010d", i)))
+		}
+	}
+
+	var maxWorkers, maxFiles int64
+	callb := func(db *Nitro) ItemCallback {
+		return func(*ItemEntry) {
+			u := db.ResourceUsage()
+			for _, p := range []struct {
+				v   int
+				max *int64
+			}{{u.Workers, &maxWorkers}, {u.OpenFiles, &maxFiles}} {
+				for old := atomic.LoadInt64(p.max); int64(p.v) > old; old = atomic.LoadInt64(p.max) {
+					if atomic.CompareAndSwapInt64(p.max, old, int64(p.v)) {
+						break
+					}
+				}
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i, db := range []*Nitro{db1, db2} {
+		wg.Add(1)
+		go func(i int, db *Nitro) {
+			defer wg.Done()
+			snap, _ := db.NewSnapshot()
+			defer snap.Close()
+			if err := db.StoreToDisk(fmt.Sprintf("mem/db%d", i), snap, 8, callb(db)); err != nil {
+				t.Errorf("Expected no error. got=%v", err)
+			}
+		}(i, db)
+	}
+	wg.Wait()
+
+	if maxWorkers > 2 || maxWorkers < 1 {
+		t.Errorf("Expected at most 2 workers, got %d", maxWorkers)
+	}
+
+	if maxFiles > 2 {
+		t.Errorf("Expected at most 2 open data files, got %d", maxFiles)
+	}
+
+	bs, _ := readFile(db1.fs, "mem/db0/data/files.json")
+	if n := strings.Count(string(bs), "shard-"); n > 2 {
+		t.Errorf("Expected at most 2 data files, got %s", bs)
+	}
+
+	u, h := db1.ResourceUsage(), db1.Health()
+	if u.Workers != 0 || u.OpenFiles != 0 || u.BackgroundWorkers != h.GCWorkers+h.FreeWorkers {
+		t.Errorf("Unexpected resource usage %+v", u)
+	}
+
+	db3 := NewWithConfig(conf)
+	defer db3.Close()
+	snap, err := db3.LoadFromDisk("mem/db0", 8, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	if c := CountItems(snap); c != 10000 {
+		t.Errorf("Expected 10000 items, got %d", c)
+	}
+
+	if u := db3.ResourceUsage(); u.Workers != 0 || u.OpenFiles != 0 {
+		t.Errorf("Unexpected resource usage %+v", u)
+	}
+}
+
+func TestBackupInfo(t *testing.T) {
+	conf := testConf
+	conf.SetFileSystem(NewMemFileSystem())
+	conf.UseSeqnos()
+	db := NewWithConfig(conf)
+	w := db.NewWriter()
+	for i := 0; i < 100; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	t0 := time.Now()
+	snap, _ := db.NewSnapshot()
+	if snap.Created().Before(t0) || snap.Seqno() != 100 {
+		t.Errorf("Unexpected snapshot info %v %d", snap.Created(), snap.Seqno())
+	}
+
+	if _, err := db.BackupInfo("mem/db.dump"); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, got %v", err)
+	}
+
+	opts := DumpOptions{Label: "hourly"}
+	if err := db.StoreToDiskWithOptions("mem/db.dump", snap, 4, opts); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	info, err := db.BackupInfo("mem/db.dump")
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	if info.UUID != db.UUID() || info.Items != 100 || info.Dirty || info.SnapshotSn != snap.sn ||
+		info.Seqno != 100 || !info.Created.Equal(snap.Created()) || info.Label != "hourly" {
+		t.Errorf("Unexpected backup info %+v", info)
+	}
+	snap.Close()
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	snap, err = db.LoadFromDisk("mem/db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	defer snap.Close()
+
+	if snap.Label() != "hourly" || !snap.Created().Equal(info.Created) || snap.Seqno() != 100 {
+		t.Errorf("Unexpected restored snapshot info %s %v %d", snap.Label(), snap.Created(), snap.Seqno())
+	}
+}
+
+func TestPortableBackupFormat(t *testing.T) {
+	// Backup encodings should not depend on the host byte order
+	conf := testConf
+	conf.UseItemMetadata(2)
+	conf.UseSeqnos()
+	conf.SetFileSystem(NewMemFileSystem())
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	n := w.PutWithMeta([]byte("abc"), []byte{0xaa, 0xbb})
+
+	var out bytes.Buffer
+	buf := make([]byte, encodeBufSize)
+	if err := db.EncodeItem((*Item)(n.Item()), buf, &out); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	exp := []byte{0, 3, 'a', 'b', 'c', 0xaa, 0xbb, 0, 0, 0, 0, 0, 0, 0, 1}
+	if !bytes.Equal(out.Bytes(), exp) {
+		t.Errorf("Unexpected encoding %v", out.Bytes())
+	}
+
+	itm, err := db.DecodeItem(buf, bytes.NewReader(exp))
+	if err != nil || string(itm.Bytes()) != "abc" || db.ItemSeqno(itm) != 1 {
+		t.Errorf("Unexpected decoded item %v", err)
+	}
+
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+	if err := db.StoreToDisk("mem/db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	bs, _ := readFile(db.fs, "mem/db.dump/"+dumpMetaFile)
+	if !strings.Contains(string(bs), fmt.Sprintf(`"version":%d`, dumpVersion)) {
+		t.Errorf("Expected format version in %s", bs)
+	}
+
+	// Backups from newer incompatible versions are rejected
+	bs = bytes.Replace(bs, []byte(`"version":1`), []byte(`"version":99`), 1)
+	writeFile(db.fs, "mem/db.dump/"+dumpMetaFile, bs, 0660)
+	db2 := NewWithConfig(conf)
+	defer db2.Close()
+	if _, err := db2.LoadFromDisk("mem/db.dump", 4, nil); err != ErrUnsupportedBackup {
+		t.Errorf("Expected unsupported backup error, got %v", err)
+	}
+}
+
+func TestVerifiedRestore(t *testing.T) {
+	conf := testConf
+	conf.SetFileSystem(NewMemFileSystem())
+	conf.SetMaxBackupFiles(1)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	var items []*Item
+	for i := 0; i < 100; i++ {
+		n := w.Put2([]byte(fmt.Sprintf("%010d", i)))
+		items = append(items, (*Item)(n.Item()))
+	}
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+	if err := db.StoreToDisk("mem/db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	load := func(verify bool) error {
+		db2 := NewWithConfig(conf)
+		defer db2.Close()
+		snap, err := db2.LoadFromDiskWithOptions("mem/db.dump", 4, LoadOptions{Verify: verify})
+		if err == nil {
+			snap.Close()
+		}
+		return err
+	}
+
+	if err := load(true); err != nil {
+		t.Errorf("Expected verified restore to succeed, got %v", err)
+	}
+
+	rewrite := func(order []int) {
+		fw := db.newFileWriter(RawdbFile)
+		if err := fw.Open("mem/db.dump/data/shard-0"); err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		for _, i := range order {
+			fw.WriteItem(items[i])
+		}
+		fw.Close()
+	}
+
+	// Unsorted data file
+	var order []int
+	for i := 99; i >= 0; i-- {
+		order = append(order, i)
+	}
+	rewrite(order)
+	if err := load(false); err != nil {
+		t.Errorf("Expected trusted restore to succeed, got %v", err)
+	}
+	if err := load(true); err != ErrCorruptBackup {
+		t.Errorf("Expected corrupt backup error, got %v", err)
+	}
+
+	// Truncated data file
+	rewrite([]int{0, 1, 2})
+	if err := load(true); err != ErrCorruptBackup {
+		t.Errorf("Expected corrupt backup error, got %v", err)
+	}
+}
+
+func TestGetSnapshotAsOf(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	var snaps []*Snapshot
+	var times []time.Time
+	for i := 0; i < 3; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+		snap, _ := db.NewSnapshot()
+		snaps = append(snaps, snap)
+		time.Sleep(10 * time.Millisecond)
+		times = append(times, time.Now())
+	}
+
+	if snap := db.GetSnapshotAsOf(snaps[0].Created().Add(-time.Millisecond)); snap != nil {
+		t.Errorf("Expected no snapshot before the first one")
+	}
+
+	for i := range snaps {
+		snap := db.GetSnapshotAsOf(times[i])
+		if snap != snaps[i] || CountItems(snap) != i+1 {
+			t.Errorf("Expected snapshot %d", i)
+		}
+		snap.Close()
+	}
+
+	// Closed snapshots are not retained
+	snaps[1].Close()
+	snap := db.GetSnapshotAsOf(times[1])
+	if snap != snaps[0] {
+		t.Errorf("Expected the older snapshot")
+	}
+	snap.Close()
+
+	snaps[0].Close()
+	snaps[2].Close()
+}
+
+func TestUndelete(t *testing.T) {
+	conf := testConf
+	conf.UseItemMetadata(4)
+	conf.SetUndeleteWindow(100 * time.Millisecond)
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 10; i++ {
+		w.PutWithMeta([]byte(fmt.Sprintf("%010d", i)), []byte{byte(i), 0, 0, 0})
+	}
+	snap, _ := db.NewSnapshot()
+	snap.Close()
+
+	key := []byte(fmt.Sprintf("%010d", 5))
+	if w.Undelete(key) != nil {
+		t.Errorf("Expected live item not to be undeleted")
+	}
+
+	w.Delete(key)
+	snap, _ = db.NewSnapshot()
+	snap.Close()
+	db.GC()
+
+	n := w.Undelete(key)
+	if n == nil {
+		t.Fatalf("Expected the deleted item to be restored")
+	}
+	if meta := db.ItemMeta((*Item)(n.Item())); meta[0] != 5 {
+		t.Errorf("Expected item metadata to be restored, got %v", meta)
+	}
+
+	snap, _ = db.NewSnapshot()
+	if CountItems(snap) != 10 {
+		t.Errorf("Expected 10 items")
+	}
+	snap.Close()
+
+	// Items are collected once the window elapses
+	key = []byte(fmt.Sprintf("%010d", 7))
+	w.Delete(key)
+	snap, _ = db.NewSnapshot()
+	snap.Close()
+	time.Sleep(150 * time.Millisecond)
+	db.GC()
+	if w.Undelete(key) != nil {
+		t.Errorf("Expected item outside the window not to be undeleted")
+	}
+}
+
+func TestMergeIterator(t *testing.T) {
+	var snaps []*Snapshot
+	for p := 0; p < 3; p++ {
+		db := New()
+		defer db.Close()
+		w := db.NewWriter()
+		for i := p; i < 30; i += 3 {
+			w.Put([]byte(fmt.Sprintf("%010d", i)))
+		}
+		// Overlapping keys
+		w.Put([]byte(fmt.Sprintf("%010d", 100)))
+		snap, _ := db.NewSnapshot()
+		snaps = append(snaps, snap)
+	}
+
+	merge := func(policy MergePolicy) (keys []string, srcs []int) {
+		var iters []*Iterator
+		for _, snap := range snaps {
+			iters = append(iters, snap.NewIterator())
+		}
+		mit := NewMergeIterator(iters, policy)
+		defer mit.Close()
+		for mit.SeekFirst(); mit.Valid(); mit.Next() {
+			keys = append(keys, string(mit.Get()))
+			srcs = append(srcs, mit.Source())
+		}
+		return
+	}
+
+	keys, srcs := merge(MergeKeepAll)
+	if len(keys) != 33 {
+		t.Fatalf("Expected 33 items, got %d", len(keys))
+	}
+	for i := 0; i < 30; i++ {
+		if keys[i] != fmt.Sprintf("%010d", i) {
+			t.Errorf("Expected key %d, got %s", i, keys[i])
+		}
+	}
+	if srcs[30] != 0 || srcs[31] != 1 || srcs[32] != 2 {
+		t.Errorf("Expected equal keys in iterator order, got %v", srcs[30:])
+	}
+
+	keys, srcs = merge(MergeKeepFirst)
+	if len(keys) != 31 || srcs[30] != 0 {
+		t.Errorf("Expected the first duplicate, got %d items from %d", len(keys), srcs[len(srcs)-1])
+	}
+
+	keys, srcs = merge(MergeKeepLast)
+	if len(keys) != 31 || srcs[30] != 2 {
+		t.Errorf("Expected the last duplicate, got %d items from %d", len(keys), srcs[len(srcs)-1])
+	}
+
+	var iters []*Iterator
+	for _, snap := range snaps {
+		iters = append(iters, snap.NewIterator())
+	}
+	mit := NewMergeIterator(iters, MergeKeepFirst)
+	mit.Seek([]byte(fmt.Sprintf("%010d", 25)))
+	if !mit.Valid() || string(mit.Get()) != fmt.Sprintf("%010d", 25) {
+		t.Errorf("Expected seek to find the key")
+	}
+	mit.Close()
+
+	for _, snap := range snaps {
+		snap.Close()
+	}
+}
+
+func TestReadReplica(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	r := db.NewReadReplica(10 * time.Millisecond)
+	defer r.Close()
+
+	waitFor := func(count int) {
+		for i := 0; r.Count() != count; i++ {
+			if i == 1000 {
+				t.Fatalf("Expected replica to have %d items, got %d", count, r.Count())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	r.Update(snap)
+	snap.Close()
+	waitFor(1000)
+
+	if _, ok := r.Get([]byte(fmt.Sprintf("%010d", 500))); !ok {
+		t.Errorf("Expected item to be found")
+	}
+	if _, ok := r.Get([]byte("x")); ok {
+		t.Errorf("Expected absent item not to be found")
+	}
+
+	itr := r.NewIterator()
+	for i := 0; i < 500; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ = db.NewSnapshot()
+	r.Update(snap)
+	snap.Close()
+	waitFor(500)
+
+	// Iterators keep using the copy they were created with
+	var n int
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		n++
+	}
+	if n != 1000 {
+		t.Errorf("Expected 1000 items, got %d", n)
+	}
+
+	if r.Seqno() != db.Seqno() || r.Staleness() <= 0 {
+		t.Errorf("Expected replica of the latest snapshot")
+	}
+}
+
+func TestSealSnapshot(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	sealed, err := snap.Seal()
+	if err != nil {
+		t.Fatalf("Expected seal to succeed, got %v", err)
+	}
+	snap.Close()
+
+	if _, err := snap.Seal(); err != ErrSnapshotClosed {
+		t.Errorf("Expected closed snapshot error, got %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ = db.NewSnapshot()
+	snap.Close()
+	db.GC()
+
+	if sealed.Count() != 1000 || sealed.Created().After(snap.Created()) {
+		t.Errorf("Expected 1000 items, got %d", sealed.Count())
+	}
+
+	var i int
+	itr := sealed.NewIterator()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if string(itr.Get()) != fmt.Sprintf("%010d", i) {
+			t.Errorf("Expected item %d, got %s", i, itr.Get())
+		}
+		i++
+	}
+
+	itr.Seek([]byte(fmt.Sprintf("%010d", 998)))
+	if !itr.Valid() || string(itr.Get()) != fmt.Sprintf("%010d", 998) {
+		t.Errorf("Expected seek to find the item")
+	}
+
+	if _, ok := sealed.Get([]byte(fmt.Sprintf("%010d", 10))); !ok {
+		t.Errorf("Expected item to be found")
+	}
+}
+
+func TestStoreToDiskAsync(t *testing.T) {
+	os.RemoveAll("db.dump")
+	defer os.RemoveAll("db.dump")
+
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	for i := 0; i < 10000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	snap, _ := db.NewSnapshot()
+	h := db.StoreToDiskAsync("db.dump", snap, 4, DumpOptions{})
+	snap.Close()
+
+	<-h.Done()
+	if err := h.Wait(); err != nil {
+		t.Fatalf("Expected backup to succeed, got %v", err)
+	}
+	if p := h.Progress(); p.ItemsDone != 10000 || p.ItemsTotal != 10000 {
+		t.Errorf("Expected 10000 items, got %+v", p)
+	}
+
+	db2 := NewWithConfig(testConf)
+	defer db2.Close()
+	snap2, err := db2.LoadFromDisk("db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected restore to succeed, got %v", err)
+	}
+	if CountItems(snap2) != 10000 {
+		t.Errorf("Expected 10000 items")
+	}
+	snap2.Close()
+
+	// The snapshot is closed by now
+	h = db.StoreToDiskAsync("db.dump", snap, 4, DumpOptions{})
+	if err := h.Wait(); err != ErrSnapshotClosed {
+		t.Errorf("Expected closed snapshot error, got %v", err)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	w := db.NewWriter()
+	n := w.Put2(make([]byte, math.MaxUint16+1))
+	var out bytes.Buffer
+	err := db.EncodeItem((*Item)(n.Item()), make([]byte, 2), &out)
+	if err != ErrItemTooLarge || !errors.Is(err, nerrors.ErrTooLarge) {
+		t.Errorf("Expected too large error, got %v", err)
+	}
+
+	if !errors.Is(errIncompleteBlob, nerrors.ErrCorrupt) || !errors.Is(errBadBlockFile, nerrors.ErrCorrupt) ||
+		!errors.Is(runRecovered(func() { panic("failure") }), nerrors.ErrInternal) {
+		t.Errorf("Expected errors to match their kinds")
+	}
+
+	snap, _ := db.NewSnapshot()
+	snap.Close()
+	if err := db.StoreToDiskAsync("db.dump", snap, 1, DumpOptions{}).Wait(); nerrors.Kind(err) != nerrors.ErrClosed {
+		t.Errorf("Expected closed error, got %v", err)
+	}
+}
+
+func TestHashIndexConcurrentGC(t *testing.T) {
+	conf := testConf
+	conf.UseHashIndex(HashIndex{})
+	db := NewWithConfig(conf)
+	defer db.Close()
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r := db.NewWriter()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			for i := 0; i < 100; i++ {
+				if n := r.GetNode([]byte(fmt.Sprintf("%010d", i))); n != nil {
+					if (*Item)(n.Item()).dataLen != 10 {
+						t.Errorf("Unexpected item")
+					}
+				}
+			}
+		}
+	}()
+
+	w := db.NewWriter()
+	for round := 0; round < 200; round++ {
+		for i := 0; i < 100; i++ {
+			w.Put([]byte(fmt.Sprintf("%010d", i)))
+		}
+		snap, _ := db.NewSnapshot()
+		snap.Close()
+
+		for i := 0; i < 100; i++ {
+			w.Delete([]byte(fmt.Sprintf("%010d", i)))
+		}
+		snap, _ = db.NewSnapshot()
+		snap.Close()
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestRollback(t *testing.T) {
+	conf := testConf
+	conf.SetFileSystem(NewMemFileSystem())
+	db := NewWithConfig(conf)
+
+	w := db.NewWriter()
+	for i := 0; i < 1000; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+	target, _ := db.NewSnapshot()
+
+	for i := 0; i < 500; i++ {
+		w.Delete([]byte(fmt.Sprintf("%010d", i)))
+	}
+	snap, _ := db.NewSnapshot()
+	snap.Close()
+	for i := 1000; i < 1200; i++ {
+		w.Put([]byte(fmt.Sprintf("%010d", i)))
+	}
+
+	if err := w.Rollback(target); err != nil {
+		t.Fatalf("Expected rollback to succeed, got %v", err)
+	}
+
+	snap, _ = db.NewSnapshot()
+	if r, err := Compare(target, snap, nil); err != nil || !r.Equal() || r.Unchanged != 1000 {
+		t.Errorf("Expected the snapshot contents, got %+v", r)
+	}
+	target.Close()
+
+	if err := w.Rollback(target); err != ErrSnapshotClosed {
+		t.Errorf("Expected closed snapshot error, got %v", err)
+	}
+
+	lineage := db.Lineage()
+	if len(lineage) != 1 || lineage[0].Type != LineageRolledBackTo || lineage[0].UUID != db.UUID() ||
+		lineage[0].Sn != target.sn {
+		t.Errorf("Unexpected lineage %v", lineage)
+	}
+
+	// The rollback is part of the lineage of restored instances
+	if err := db.StoreToDisk("mem/db.dump", snap, 4, nil); err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	db.Close()
+
+	db = NewWithConfig(conf)
+	defer db.Close()
+	snap, err := db.LoadFromDisk("mem/db.dump", 4, nil)
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+	VerifyCount(snap, 1000, t)
+	snap.Close()
+
+	lineage = db.Lineage()
+	if len(lineage) != 2 || lineage[0].Type != LineageRolledBackTo || lineage[1].Type != LineageRestoredFrom {
+		t.Errorf("Unexpected lineage %v", lineage)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"unsafe"
+)
+
+// LookupRange returns copies of upto `limit` items within [start, end) in a
+// single call. A nil start or end is unlimited and a non-positive limit
+// returns all items in the range. It avoids the per item overhead of an
+// Iterator for small range reads. The returned items share one allocation.
+// The snapshot should be held open by the caller.
+func (s *Snapshot) LookupRange(start, end []byte, limit int) [][]byte {
+	m := s.db
+	buf := m.store.MakeBuf()
+	defer m.store.FreeBuf(buf)
+	iter := m.store.NewIterator(m.iterCmp, buf)
+	defer iter.Close()
+
+	if start == nil {
+		iter.SeekFirst()
+	} else {
+		iter.Seek(unsafe.Pointer(m.newItem(start, false)))
+	}
+
+	var data []byte
+	var offsets []int
+	for ; iter.Valid() && (limit <= 0 || len(offsets) < limit); iter.Next() {
+		itm := (*Item)(iter.Get())
+		if end != nil && m.keyCmp(itm.Bytes(), end) >= 0 {
+			break
+		}
+
+		if itm.bornSn > s.sn || (itm.deadSn > 0 && itm.deadSn <= s.sn) {
+			continue
+		}
+
+		offsets = append(offsets, len(data))
+		data = append(data, itm.Bytes()...)
+	}
+
+	items := make([][]byte, len(offsets))
+	for i, off := range offsets {
+		next := len(data)
+		if i+1 < len(offsets) {
+			next = offsets[i+1]
+		}
+		items[i] = data[off:next:next]
+	}
+
+	return items
+}
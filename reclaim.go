@@ -0,0 +1,93 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReclaimStats describes the state of deferred memory reclamation.
+// Deleted items are unlinked by the gc workers and freed by the free workers
+// once the access barrier session holding them is terminated.
+type ReclaimStats struct {
+	// Barrier sessions closed and terminated since the instance was created
+	SessionsClosed uint64
+	SessionsFreed  uint64
+	// Barrier sessions closed per second since the previous ReclaimStats call
+	SessionRate float64
+
+	// Garbage lists waiting for the gc and free workers
+	GCQueueLen   int
+	FreeQueueLen int
+
+	// Bytes of items which are unlinked, but not yet freed. Tracked only if
+	// memory management is enabled.
+	DeferredBytes    int64
+	MaxDeferredBytes int64
+}
+
+func (s ReclaimStats) String() string {
+	return fmt.Sprintf(
+		"sessions_closed = %d\n"+
+			"sessions_freed  = %d\n"+
+			"session_rate    = %.2f\n"+
+			"gc_queue_len    = %d\n"+
+			"free_queue_len  = %d\n"+
+			"deferred_bytes  = %d\n"+
+			"max_deferred    = %d\n",
+		s.SessionsClosed, s.SessionsFreed, s.SessionRate, s.GCQueueLen,
+		s.FreeQueueLen, s.DeferredBytes, s.MaxDeferredBytes)
+}
+
+type reclaimState struct {
+	deferredBytes    int64
+	maxDeferredBytes int64
+
+	sync.Mutex
+	lastTime   time.Time
+	lastClosed uint64
+}
+
+func (r *reclaimState) addDeferred(sz int64) {
+	curr := atomic.AddInt64(&r.deferredBytes, sz)
+	for {
+		max := atomic.LoadInt64(&r.maxDeferredBytes)
+		if curr <= max || atomic.CompareAndSwapInt64(&r.maxDeferredBytes, max, curr) {
+			return
+		}
+	}
+}
+
+// ReclaimStats returns memory reclamation statistics
+func (m *Nitro) ReclaimStats() ReclaimStats {
+	closed, freed := m.store.GetAccesBarrier().Sessions()
+	s := ReclaimStats{
+		SessionsClosed:   closed,
+		SessionsFreed:    freed,
+		GCQueueLen:       len(m.gcchan),
+		FreeQueueLen:     len(m.freechan),
+		DeferredBytes:    atomic.LoadInt64(&m.reclaim.deferredBytes),
+		MaxDeferredBytes: atomic.LoadInt64(&m.reclaim.maxDeferredBytes),
+	}
+
+	m.reclaim.Lock()
+	defer m.reclaim.Unlock()
+	now := time.Now()
+	if d := now.Sub(m.reclaim.lastTime).Seconds(); !m.reclaim.lastTime.IsZero() && d > 0 {
+		s.SessionRate = float64(closed-m.reclaim.lastClosed) / d
+	}
+	m.reclaim.lastTime = now
+	m.reclaim.lastClosed = closed
+
+	return s
+}
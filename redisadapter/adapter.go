@@ -0,0 +1,449 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package redisadapter serves a subset of the Redis protocol (RESP) from a
+// Nitro instance, so that existing Redis clients can be pointed at Nitro
+// during migration testing.
+//
+// Supported commands are PING, GET, SET (with EX/PX), DEL, EXPIRE, TTL and
+// SCAN (with MATCH/COUNT). The Nitro instance should use the nitro.CompareKV
+// comparator. Values are stored with an 8 byte expiry time prefix and expired
+// keys are removed lazily on access or by PurgeExpired().
+package redisadapter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"github.com/t3rm1n4l/nitro"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	expiryLen        = 8
+	defaultScanCount = 10
+)
+
+// Adapter serves RESP connections for a Nitro instance
+type Adapter struct {
+	db *nitro.Nitro
+
+	sync.Mutex
+	w     *nitro.Writer
+	snap  *nitro.Snapshot
+	dirty bool
+	now   func() time.Time
+
+	closed bool
+	conns  map[net.Conn]struct{}
+	wg     sync.WaitGroup
+
+	maxBulkLen int
+}
+
+// New creates an adapter for the Nitro instance
+func New(db *nitro.Nitro) *Adapter {
+	return &Adapter{
+		db:    db,
+		w:     db.NewWriter(),
+		dirty: true,
+		now:   time.Now,
+		conns: make(map[net.Conn]struct{}),
+
+		maxBulkLen: defaultMaxBulkLen,
+	}
+}
+
+// SetMaxBulkLen limits the size of the command arguments accepted from
+// clients. Commands with larger arguments are rejected with a protocol error
+// and the connection is closed. It should be called before serving.
+func (a *Adapter) SetMaxBulkLen(n int) {
+	a.maxBulkLen = n
+}
+
+// Serve accepts connections until the listener is closed
+func (a *Adapter) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		a.Lock()
+		if a.closed {
+			a.Unlock()
+			conn.Close()
+			return nil
+		}
+		a.conns[conn] = struct{}{}
+		a.wg.Add(1)
+		a.Unlock()
+
+		go func() {
+			defer a.wg.Done()
+			a.ServeConn(conn)
+			a.Lock()
+			delete(a.conns, conn)
+			a.Unlock()
+		}()
+	}
+}
+
+// Close terminates active connections and releases the snapshot held by the
+// adapter. The Nitro instance should be closed by the caller afterwards.
+func (a *Adapter) Close() {
+	a.Lock()
+	a.closed = true
+	for conn := range a.conns {
+		conn.Close()
+	}
+	a.Unlock()
+	a.wg.Wait()
+
+	a.Lock()
+	defer a.Unlock()
+	if a.snap != nil {
+		a.snap.Close()
+		a.snap = nil
+	}
+}
+
+// ServeConn serves commands from a connection until it is closed
+func (a *Adapter) ServeConn(conn io.ReadWriteCloser) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := respWriter{bufio.NewWriter(conn)}
+	for {
+		args, err := readCommand(r, a.maxBulkLen)
+		if err != nil {
+			if err == errProtocol {
+				w.err(err.Error())
+				w.Flush()
+			}
+			return
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		if strings.ToUpper(string(args[0])) == "QUIT" {
+			w.simple("OK")
+			w.Flush()
+			return
+		}
+
+		a.execute(w, args)
+		if r.Buffered() == 0 {
+			if w.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+func encodeValue(v []byte, expiry time.Time) []byte {
+	buf := make([]byte, expiryLen+len(v))
+	if !expiry.IsZero() {
+		binary.BigEndian.PutUint64(buf, uint64(expiry.UnixNano()))
+	}
+	copy(buf[expiryLen:], v)
+	return buf
+}
+
+func decodeValue(bs []byte) (v []byte, expiry time.Time) {
+	if ts := binary.BigEndian.Uint64(bs); ts != 0 {
+		expiry = time.Unix(0, int64(ts))
+	}
+	return bs[expiryLen:], expiry
+}
+
+func (a *Adapter) expired(expiry time.Time) bool {
+	return !expiry.IsZero() && !a.now().Before(expiry)
+}
+
+// get returns the current value of a live key. Expired keys are deleted.
+// Caller should hold the lock.
+func (a *Adapter) get(key []byte) (v []byte, expiry time.Time, ok bool) {
+	n := a.w.GetNode(nitro.KVToBytes(key, nil))
+	if n == nil {
+		return nil, expiry, false
+	}
+
+	_, val := nitro.KVFromBytes((*nitro.Item)(n.Item()).Bytes())
+	v, expiry = decodeValue(val)
+	if a.expired(expiry) {
+		a.w.DeleteNode(n)
+		a.dirty = true
+		return nil, expiry, false
+	}
+
+	// Empty values are not nil, which would be returned as a missing key
+	return append([]byte{}, v...), expiry, true
+}
+
+func (a *Adapter) set(key, v []byte, expiry time.Time) {
+	a.w.Delete(nitro.KVToBytes(key, nil))
+	a.w.Put(nitro.KVToBytes(key, encodeValue(v, expiry)))
+	a.dirty = true
+}
+
+func (a *Adapter) del(key []byte) bool {
+	_, _, ok := a.get(key)
+	if ok {
+		a.w.Delete(nitro.KVToBytes(key, nil))
+		a.dirty = true
+	}
+	return ok
+}
+
+// snapshot returns an opened snapshot of the current state.
+// Caller should hold the lock.
+func (a *Adapter) snapshot() (*nitro.Snapshot, error) {
+	if a.dirty || a.snap == nil {
+		snap, err := a.db.NewSnapshot()
+		if err != nil {
+			return nil, err
+		}
+
+		if a.snap != nil {
+			a.snap.Close()
+		}
+		a.snap = snap
+		a.dirty = false
+	}
+
+	a.snap.Open()
+	return a.snap, nil
+}
+
+// PurgeExpired deletes all expired keys and returns the number of keys deleted
+func (a *Adapter) PurgeExpired() (int, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	snap, err := a.snapshot()
+	if err != nil {
+		return 0, err
+	}
+	defer snap.Close()
+
+	var keys [][]byte
+	itr := snap.NewIterator()
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		k, val := nitro.KVFromBytes(itr.Get())
+		if _, expiry := decodeValue(val); a.expired(expiry) {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+	}
+	itr.Close()
+
+	for _, k := range keys {
+		a.w.Delete(nitro.KVToBytes(k, nil))
+	}
+	a.dirty = a.dirty || len(keys) > 0
+	return len(keys), nil
+}
+
+func (a *Adapter) execute(w respWriter, args [][]byte) {
+	a.Lock()
+	defer a.Unlock()
+
+	cmd := strings.ToUpper(string(args[0]))
+	args = args[1:]
+	switch cmd {
+	case "PING":
+		if len(args) > 0 {
+			w.bulk(args[0])
+		} else {
+			w.simple("PONG")
+		}
+
+	case "COMMAND":
+		w.arrayHeader(0)
+
+	case "GET":
+		if len(args) != 1 {
+			w.err("wrong number of arguments for 'get' command")
+			return
+		}
+
+		v, _, _ := a.get(args[0])
+		w.bulk(v)
+
+	case "SET":
+		a.execSet(w, args)
+
+	case "DEL":
+		if len(args) == 0 {
+			w.err("wrong number of arguments for 'del' command")
+			return
+		}
+
+		var count int64
+		for _, k := range args {
+			if a.del(k) {
+				count++
+			}
+		}
+		w.integer(count)
+
+	case "EXPIRE":
+		if len(args) != 2 {
+			w.err("wrong number of arguments for 'expire' command")
+			return
+		}
+
+		secs, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			w.err("value is not an integer or out of range")
+			return
+		}
+
+		v, _, ok := a.get(args[0])
+		if !ok {
+			w.integer(0)
+			return
+		}
+
+		a.set(args[0], v, a.now().Add(time.Duration(secs)*time.Second))
+		w.integer(1)
+
+	case "TTL":
+		if len(args) != 1 {
+			w.err("wrong number of arguments for 'ttl' command")
+			return
+		}
+
+		_, expiry, ok := a.get(args[0])
+		switch {
+		case !ok:
+			w.integer(-2)
+		case expiry.IsZero():
+			w.integer(-1)
+		default:
+			w.integer(int64((expiry.Sub(a.now()) + time.Second - 1) / time.Second))
+		}
+
+	case "SCAN":
+		a.execScan(w, args)
+
+	default:
+		w.err("unknown command '" + strings.ToLower(cmd) + "'")
+	}
+}
+
+func (a *Adapter) execSet(w respWriter, args [][]byte) {
+	var expiry time.Time
+
+	if len(args) != 2 && len(args) != 4 {
+		w.err("syntax error")
+		return
+	}
+
+	if len(args) == 4 {
+		n, err := strconv.ParseInt(string(args[3]), 10, 64)
+		if err != nil || n <= 0 {
+			w.err("invalid expire time in 'set' command")
+			return
+		}
+
+		switch strings.ToUpper(string(args[2])) {
+		case "EX":
+			expiry = a.now().Add(time.Duration(n) * time.Second)
+		case "PX":
+			expiry = a.now().Add(time.Duration(n) * time.Millisecond)
+		default:
+			w.err("syntax error")
+			return
+		}
+	}
+
+	a.set(args[0], args[1], expiry)
+	w.simple("OK")
+}
+
+// execScan implements SCAN using the number of keys already visited as cursor.
+// Every call walks the keys from the beginning of a fresh snapshot.
+func (a *Adapter) execScan(w respWriter, args [][]byte) {
+	if len(args) == 0 {
+		w.err("wrong number of arguments for 'scan' command")
+		return
+	}
+
+	cursor, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil || cursor < 0 {
+		w.err("invalid cursor")
+		return
+	}
+
+	pattern := ""
+	count := defaultScanCount
+	for i := 1; i+1 < len(args); i += 2 {
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			pattern = string(args[i+1])
+		case "COUNT":
+			if count, err = strconv.Atoi(string(args[i+1])); err != nil || count <= 0 {
+				w.err("syntax error")
+				return
+			}
+		default:
+			w.err("syntax error")
+			return
+		}
+	}
+
+	snap, err := a.snapshot()
+	if err != nil {
+		w.err(err.Error())
+		return
+	}
+	defer snap.Close()
+
+	var keys [][]byte
+	pos := int64(0)
+	itr := snap.NewIterator()
+	defer itr.Close()
+	for itr.SeekFirst(); itr.Valid() && len(keys) < count; itr.Next() {
+		if pos++; pos <= cursor {
+			continue
+		}
+
+		k, val := nitro.KVFromBytes(itr.Get())
+		if _, expiry := decodeValue(val); a.expired(expiry) {
+			continue
+		}
+
+		if pattern != "" {
+			if ok, _ := path.Match(pattern, string(k)); !ok {
+				continue
+			}
+		}
+		keys = append(keys, k)
+	}
+
+	next := pos
+	if !itr.Valid() {
+		next = 0
+	}
+
+	w.arrayHeader(2)
+	w.bulk([]byte(strconv.FormatInt(next, 10)))
+	w.arrayHeader(len(keys))
+	for _, k := range keys {
+		w.bulk(k)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package redisadapter
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/t3rm1n4l/nitro"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *client) do(args ...string) interface{} {
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, a := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(a), a)
+	}
+
+	if _, err := c.conn.Write([]byte(cmd)); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return c.reply()
+}
+
+func (c *client) reply() interface{} {
+	line, err := readLine(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:])
+	case '-':
+		return fmt.Errorf("%s", line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(string(line[1:]), 10, 64)
+		return n
+	case '$':
+		n, _ := strconv.Atoi(string(line[1:]))
+		if n < 0 {
+			return nil
+		}
+		bs := make([]byte, n+2)
+		io.ReadFull(c.r, bs)
+		return string(bs[:n])
+	case '*':
+		n, _ := strconv.Atoi(string(line[1:]))
+		res := make([]interface{}, n)
+		for i := range res {
+			res[i] = c.reply()
+		}
+		return res
+	}
+
+	c.t.Fatalf("Unexpected reply %s", line)
+	return nil
+}
+
+func TestAdapter(t *testing.T) {
+	cfg := nitro.DefaultConfig()
+	cfg.SetKeyComparator(nitro.CompareKV)
+	db := nitro.NewWithConfig(cfg)
+	defer db.Close()
+
+	a := New(db)
+	defer a.Close()
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	s, conn := net.Pipe()
+	go a.ServeConn(s)
+	defer conn.Close()
+	c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	if r := c.do("PING"); r != "PONG" {
+		t.Errorf("Unexpected ping reply %v", r)
+	}
+
+	for i := 0; i < 20; i++ {
+		if r := c.do("SET", fmt.Sprintf("key-%02d", i), fmt.Sprint(i)); r != "OK" {
+			t.Fatalf("Unexpected set reply %v", r)
+		}
+	}
+
+	c.do("SET", "key-01", "updated", "EX", "10")
+	if r := c.do("GET", "key-01"); r != "updated" {
+		t.Errorf("Unexpected value %v", r)
+	}
+
+	if r := c.do("TTL", "key-01"); r != int64(10) {
+		t.Errorf("Unexpected ttl %v", r)
+	}
+
+	if r := c.do("DEL", "key-02", "key-03", "missing"); r != int64(2) {
+		t.Errorf("Unexpected del reply %v", r)
+	}
+
+	if r := c.do("GET", "key-02"); r != nil {
+		t.Errorf("Expected nil, got %v", r)
+	}
+
+	if r := c.do("EXPIRE", "key-04", "5"); r != int64(1) {
+		t.Errorf("Unexpected expire reply %v", r)
+	}
+
+	now = now.Add(6 * time.Second)
+	if r := c.do("GET", "key-04"); r != nil {
+		t.Errorf("Expected expired key, got %v", r)
+	}
+
+	if r := c.do("GET", "key-01"); r != "updated" {
+		t.Errorf("Unexpected value %v", r)
+	}
+
+	now = now.Add(5 * time.Second)
+	if n, err := a.PurgeExpired(); err != nil || n != 1 {
+		t.Errorf("Unexpected purge result %d %v", n, err)
+	}
+
+	var keys []string
+	cursor := "0"
+	for {
+		r := c.do("SCAN", cursor, "COUNT", "4").([]interface{})
+		cursor = r[0].(string)
+		for _, k := range r[1].([]interface{}) {
+			keys = append(keys, k.(string))
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+
+	if len(keys) != 16 || keys[0] != "key-00" || keys[1] != "key-05" {
+		t.Errorf("Unexpected scan result %v", keys)
+	}
+
+	r := c.do("SCAN", "0", "MATCH", "key-1*", "COUNT", "100").([]interface{})
+	if len(r[1].([]interface{})) != 10 {
+		t.Errorf("Unexpected match result %v", r)
+	}
+
+	if err, ok := c.do("HGET", "x").(error); !ok || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("Expected unknown command error, got %v", err)
+	}
+
+	c.do("SET", "empty", "")
+	if r := c.do("GET", "empty"); r != "" {
+		t.Errorf("Expected empty value, got %v", r)
+	}
+}
+
+func TestProtocolLimits(t *testing.T) {
+	cfg := nitro.DefaultConfig()
+	cfg.SetKeyComparator(nitro.CompareKV)
+	db := nitro.NewWithConfig(cfg)
+	defer db.Close()
+
+	a := New(db)
+	defer a.Close()
+	a.SetMaxBulkLen(16)
+
+	for _, cmd := range []string{
+		"*1000000000\r\n",
+		"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$17\r\n",
+	} {
+		s, conn := net.Pipe()
+		go a.ServeConn(s)
+		c := &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+		go conn.Write([]byte(cmd))
+		if err, ok := c.reply().(error); !ok || !strings.Contains(err.Error(), errProtocol.Error()) {
+			t.Errorf("Expected protocol error for %q, got %v", cmd, err)
+		}
+		conn.Close()
+	}
+
+	r := bufio.NewReader(strings.NewReader("*x\r\n"))
+	if _, err := readCommand(r, defaultMaxBulkLen); !errors.Is(err, nerrors.ErrCorrupt) {
+		t.Errorf("Expected corrupt input error, got %v", err)
+	}
+}
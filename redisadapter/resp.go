@@ -0,0 +1,110 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package redisadapter
+
+import (
+	"bufio"
+	"bytes"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"io"
+	"strconv"
+)
+
+const (
+	maxArgs           = 1024 * 1024
+	defaultMaxBulkLen = 16 * 1024 * 1024
+)
+
+var errProtocol = nerrors.New(nerrors.ErrCorrupt, "Protocol error")
+
+// readCommand reads a RESP array of bulk strings or an inline command.
+// Bulk strings are limited to maxBulkLen bytes.
+func readCommand(r *bufio.Reader, maxBulkLen int) ([][]byte, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxArgs {
+		return nil, errProtocol
+	}
+
+	// The arguments are allocated as they are read, since the count is
+	// provided by the client
+	var args [][]byte
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+
+		l, err := strconv.Atoi(string(line[1:]))
+		if err != nil || l < 0 || l > maxBulkLen {
+			return nil, errProtocol
+		}
+
+		buf := make([]byte, l+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, buf[:l])
+	}
+
+	return args, nil
+}
+
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+type respWriter struct {
+	*bufio.Writer
+}
+
+func (w respWriter) simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+func (w respWriter) err(s string) {
+	w.WriteString("-ERR " + s + "\r\n")
+}
+
+func (w respWriter) integer(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w respWriter) bulk(bs []byte) {
+	if bs == nil {
+		w.WriteString("$-1\r\n")
+		return
+	}
+
+	w.WriteString("$" + strconv.Itoa(len(bs)) + "\r\n")
+	w.Write(bs)
+	w.WriteString("\r\n")
+}
+
+func (w respWriter) arrayHeader(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
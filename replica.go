@@ -0,0 +1,173 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+type replicaVersion struct {
+	*itemArray
+	created time.Time
+	seqno   uint64
+}
+
+// ReadReplica is a compact sorted array copy of a recent snapshot. Readers
+// use it without locks or skiplist traversal, which offloads read-heavy
+// components from the primary skiplist. The copy is rebuilt in the
+// background from the snapshots offered using Update(), at most once per
+// rebuild interval, so that it lags the offered snapshots by about the
+// interval plus the rebuild time.
+type ReadReplica struct {
+	db       *Nitro
+	interval time.Duration
+	curr     unsafe.Pointer
+
+	sync.Mutex
+	pending *Snapshot
+	notify  chan struct{}
+	closed  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewReadReplica creates an empty read replica which is rebuilt at most once
+// per interval
+func (m *Nitro) NewReadReplica(interval time.Duration) *ReadReplica {
+	r := &ReadReplica{
+		db:       m,
+		interval: interval,
+		notify:   make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+
+	atomic.StorePointer(&r.curr, unsafe.Pointer(&replicaVersion{
+		itemArray: &itemArray{cmp: m.keyCmp},
+	}))
+
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+// Update offers a snapshot for the next rebuild. The replica opens the
+// snapshot and releases it once it is copied or superseded. Update has the
+// signature of a SnapshotHook, so that it can be registered with an
+// AutoSnapshotter.
+func (r *ReadReplica) Update(snap *Snapshot) {
+	if !snap.Open() {
+		return
+	}
+
+	r.Lock()
+	prev := r.pending
+	r.pending = snap
+	r.Unlock()
+
+	if prev != nil {
+		prev.Close()
+	}
+
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (r *ReadReplica) run() {
+	defer r.wg.Done()
+	var last time.Time
+	for {
+		select {
+		case <-r.closed:
+			return
+		case <-r.notify:
+		}
+
+		if wait := r.interval - time.Since(last); wait > 0 {
+			select {
+			case <-r.closed:
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		r.Lock()
+		snap := r.pending
+		r.pending = nil
+		r.Unlock()
+
+		if snap != nil {
+			last = time.Now()
+			// The replica holds the snapshot open, so that the copy can
+			// not fail
+			if a, err := r.db.buildItemArray(snap); err == nil {
+				v := &replicaVersion{
+					itemArray: a,
+					created:   snap.created,
+					seqno:     snap.seqno,
+				}
+				atomic.StorePointer(&r.curr, unsafe.Pointer(v))
+			}
+			snap.Close()
+		}
+	}
+}
+
+func (r *ReadReplica) version() *replicaVersion {
+	return (*replicaVersion)(atomic.LoadPointer(&r.curr))
+}
+
+// Get returns the item with the key of bs. The item should not be modified.
+func (r *ReadReplica) Get(bs []byte) ([]byte, bool) {
+	return r.version().lookup(bs)
+}
+
+// NewIterator creates an iterator over the current copy. The iterator keeps
+// using the same copy after later rebuilds.
+func (r *ReadReplica) NewIterator() *ArrayIterator {
+	return &ArrayIterator{a: r.version().itemArray}
+}
+
+// Count returns the number of items in the current copy
+func (r *ReadReplica) Count() int {
+	return r.version().count
+}
+
+// Seqno returns the mutation sequence number of the snapshot copied last
+func (r *ReadReplica) Seqno() uint64 {
+	return r.version().seqno
+}
+
+// Staleness returns the time since the snapshot copied last was created. It
+// is zero until the first copy is built.
+func (r *ReadReplica) Staleness() time.Duration {
+	v := r.version()
+	if v.created.IsZero() {
+		return 0
+	}
+	return time.Since(v.created)
+}
+
+// Close stops the rebuilds and releases the pending snapshot. The replica
+// can be read until it is garbage collected.
+func (r *ReadReplica) Close() {
+	close(r.closed)
+	r.wg.Wait()
+
+	r.Lock()
+	if r.pending != nil {
+		r.pending.Close()
+		r.pending = nil
+	}
+	r.Unlock()
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"os"
+	"runtime"
+	"sync/atomic"
+)
+
+// WorkerPool is a budget of worker goroutines for disk backup, restore,
+// bulk load and visitor operations. A pool can be shared by many Nitro
+// instances to bound the goroutines used by a process hosting many stores.
+type WorkerPool struct {
+	tokens chan struct{}
+}
+
+// NewWorkerPool creates a pool which allows upto n concurrent workers
+func NewWorkerPool(n int) *WorkerPool {
+	p := &WorkerPool{tokens: make(chan struct{}, n)}
+	for i := 0; i < n; i++ {
+		p.tokens <- struct{}{}
+	}
+	return p
+}
+
+// acquire waits for one worker token and takes upto n-1 more if they are
+// available without waiting. It returns the number of tokens taken.
+func (p *WorkerPool) acquire(n int) int {
+	<-p.tokens
+	got := 1
+	for ; got < n; got++ {
+		select {
+		case <-p.tokens:
+		default:
+			return got
+		}
+	}
+	return got
+}
+
+func (p *WorkerPool) release(n int) {
+	for i := 0; i < n; i++ {
+		p.tokens <- struct{}{}
+	}
+}
+
+// SetWorkerPool makes the operations which take a concurrency argument run
+// as many workers as the pool can spare, between one and the requested
+// concurrency. By default, the requested concurrency is used.
+func (cfg *Config) SetWorkerPool(p *WorkerPool) {
+	cfg.workerPool = p
+}
+
+// SetMaxBackupFiles limits the number of data files written by a disk backup
+// and hence the files held open while it runs. By default, a backup writes
+// one data file per CPU. Delta files are always written per writer.
+func (cfg *Config) SetMaxBackupFiles(n int) {
+	cfg.maxBackupFiles = n
+}
+
+func (m *Nitro) backupShards() int {
+	shards := runtime.NumCPU()
+	if m.maxBackupFiles > 0 && shards > m.maxBackupFiles {
+		shards = m.maxBackupFiles
+	}
+	return shards
+}
+
+func (m *Nitro) acquireWorkers(n int) int {
+	if m.workerPool != nil && n > 0 {
+		n = m.workerPool.acquire(n)
+	}
+	atomic.AddInt64(&m.resources.workers, int64(n))
+	return n
+}
+
+func (m *Nitro) releaseWorkers(n int) {
+	atomic.AddInt64(&m.resources.workers, -int64(n))
+	if m.workerPool != nil && n > 0 {
+		m.workerPool.release(n)
+	}
+}
+
+type resourceState struct {
+	workers   int64
+	openFiles int64
+}
+
+// countedFile keeps the open file count of a Nitro instance
+type countedFile struct {
+	File
+	count  *int64
+	closed int32
+}
+
+func (f *countedFile) Close() error {
+	if atomic.CompareAndSwapInt32(&f.closed, 0, 1) {
+		atomic.AddInt64(f.count, -1)
+	}
+	return f.File.Close()
+}
+
+func (m *Nitro) openFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := m.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	atomic.AddInt64(&m.resources.openFiles, 1)
+	return &countedFile{File: f, count: &m.resources.openFiles}, nil
+}
+
+// ResourceUsage describes the goroutines and files used by a Nitro instance
+type ResourceUsage struct {
+	// Per writer gc and free workers
+	BackgroundWorkers int
+	// Workers of running backup, restore, bulk load and visitor operations
+	Workers int
+	// Backup files held open
+	OpenFiles int
+}
+
+// ResourceUsage returns the goroutines and files currently in use
+func (m *Nitro) ResourceUsage() ResourceUsage {
+	h := &m.health
+	return ResourceUsage{
+		BackgroundWorkers: int(atomic.LoadInt32(&h.gcWorkers) + atomic.LoadInt32(&h.freeWorkers)),
+		Workers:           int(atomic.LoadInt64(&m.resources.workers)),
+		OpenFiles:         int(atomic.LoadInt64(&m.resources.openFiles)),
+	}
+}
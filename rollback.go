@@ -0,0 +1,75 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"github.com/t3rm1n4l/nitro/skiplist"
+	"time"
+)
+
+type rollbackItem struct {
+	data []byte
+	meta []byte
+}
+
+// Rollback reverts the live items of the Nitro instance to the items of the
+// snapshot. Items inserted after the snapshot are deleted and items deleted
+// after the snapshot are inserted again using the writer. The rollback is
+// visible to the snapshots created afterwards and is recorded in the lineage.
+// This is a thread-unsafe API. No other writer should be active while it
+// runs.
+func (w *Writer) Rollback(snap *Snapshot) error {
+	if !snap.Open() {
+		return ErrSnapshotClosed
+	}
+	defer snap.Close()
+
+	if w.ReadOnly() {
+		return ErrReadOnly
+	}
+
+	var newer []*skiplist.Node
+	var older []rollbackItem
+
+	iter := w.store.NewIterator(w.iterCmp, w.buf)
+	for iter.SeekFirst(); iter.Valid(); iter.Next() {
+		itm := (*Item)(iter.Get())
+		switch {
+		case itm.bornSn > snap.sn && itm.deadSn == 0:
+			newer = append(newer, iter.GetNode())
+		case itm.bornSn <= snap.sn && itm.deadSn > snap.sn:
+			older = append(older, rollbackItem{
+				data: append([]byte(nil), itm.Bytes()...),
+				meta: append([]byte(nil), w.ItemMeta(itm)...),
+			})
+		}
+	}
+	iter.Close()
+
+	// Live items are deleted first, so that the older versions of their
+	// keys can be inserted again
+	for _, n := range newer {
+		w.DeleteNode(n)
+	}
+
+	for _, itm := range older {
+		w.put(itm.data, itm.meta)
+	}
+
+	w.lineage = append(w.lineage, LineageEvent{
+		Type:  LineageRolledBackTo,
+		UUID:  w.uuid,
+		Time:  time.Now(),
+		Sn:    snap.sn,
+		Label: snap.label,
+	})
+
+	return nil
+}
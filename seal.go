@@ -0,0 +1,72 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"time"
+)
+
+// SealedSnapshot is an immutable sorted array copy of a snapshot. It needs
+// about 12 bytes per item in addition to the item data and does not hold
+// back garbage collection of the Nitro instance.
+type SealedSnapshot struct {
+	*itemArray
+	created time.Time
+	seqno   uint64
+	label   string
+}
+
+// Seal copies the snapshot items into a sealed snapshot. Long-lived readers
+// can use the sealed snapshot once the snapshot is closed, which lets the
+// items deleted after the snapshot and the skiplist towers be reclaimed.
+// It returns ErrSnapshotClosed if the snapshot is already closed.
+func (s *Snapshot) Seal() (*SealedSnapshot, error) {
+	a, err := s.db.buildItemArray(s)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SealedSnapshot{
+		itemArray: a,
+		created:   s.created,
+		seqno:     s.seqno,
+		label:     s.label,
+	}, nil
+}
+
+// Count returns the number of items in the snapshot
+func (s *SealedSnapshot) Count() int {
+	return s.count
+}
+
+// Get returns the item with the key of bs. The item should not be modified.
+func (s *SealedSnapshot) Get(bs []byte) ([]byte, bool) {
+	return s.lookup(bs)
+}
+
+// NewIterator creates an iterator for the sealed snapshot
+func (s *SealedSnapshot) NewIterator() *ArrayIterator {
+	return &ArrayIterator{a: s.itemArray}
+}
+
+// Created returns the creation time of the sealed snapshot
+func (s *SealedSnapshot) Created() time.Time {
+	return s.created
+}
+
+// Seqno returns the mutation sequence number of the sealed snapshot
+func (s *SealedSnapshot) Seqno() uint64 {
+	return s.seqno
+}
+
+// Label returns the label of the sealed snapshot
+func (s *SealedSnapshot) Label() string {
+	return s.label
+}
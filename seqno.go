@@ -0,0 +1,98 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"unsafe"
+)
+
+// If sequence numbers or multiset mode are enabled, every item carries its
+// insertion sequence number after the user metadata block. It is preserved
+// by disk backups.
+const seqnoSize = 8
+
+// UseSeqnos assigns a monotonically increasing sequence number to every
+// mutation. Sequence numbers may have gaps, since failed inserts also
+// consume one. The sequence number of an item can be read using
+// Iterator.Seqno() or Nitro.ItemSeqno(). Deletes also consume a sequence
+// number, which is returned by Writer.LastSeqno().
+func (cfg *Config) UseSeqnos() {
+	cfg.seqnos = true
+}
+
+func (m *Nitro) hasSeqno() bool {
+	return m.seqnos || m.multiset
+}
+
+func (m *Nitro) metaBlockSize() int {
+	if m.hasSeqno() {
+		return m.metaSize + seqnoSize
+	}
+	return m.metaSize
+}
+
+func (m *Nitro) itemSeqBytes(itm *Item) []byte {
+	off := itemHeaderSize + uintptr(itm.dataLen) + uintptr(m.metaSize)
+	return unsafe.Slice((*byte)(unsafe.Add(unsafe.Pointer(itm), off)), seqnoSize)
+}
+
+func (m *Nitro) itemSeq(itm *Item) uint64 {
+	return binary.BigEndian.Uint64(m.itemSeqBytes(itm))
+}
+
+// assignSeq stamps the item with the next sequence number
+func (m *Nitro) assignSeq(itm *Item) uint64 {
+	if !m.hasSeqno() {
+		return 0
+	}
+
+	seq := atomic.AddUint64(&m.seq, 1)
+	binary.BigEndian.PutUint64(m.itemSeqBytes(itm), seq)
+	return seq
+}
+
+// observeSeq makes sure that sequence numbers of restored items are never reused
+func (m *Nitro) observeSeq(itm *Item) {
+	seq := m.itemSeq(itm)
+	for {
+		curr := atomic.LoadUint64(&m.seq)
+		if seq <= curr || atomic.CompareAndSwapUint64(&m.seq, curr, seq) {
+			return
+		}
+	}
+}
+
+// ItemSeqno returns the sequence number assigned to the item when it was
+// inserted. It returns 0 if sequence numbers are not enabled.
+func (m *Nitro) ItemSeqno(itm *Item) uint64 {
+	if !m.hasSeqno() {
+		return 0
+	}
+	return m.itemSeq(itm)
+}
+
+// Seqno returns the latest sequence number assigned to a mutation
+func (m *Nitro) Seqno() uint64 {
+	return atomic.LoadUint64(&m.seq)
+}
+
+// LastSeqno returns the sequence number of the last successful Put or Delete
+// performed by the writer
+func (w *Writer) LastSeqno() uint64 {
+	return w.lastSeq
+}
+
+// Seqno returns the sequence number of the current item
+func (it *Iterator) Seqno() uint64 {
+	it.check()
+	return it.snap.db.ItemSeqno((*Item)(it.iter.Get()))
+}
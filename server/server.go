@@ -0,0 +1,277 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package server exposes a Nitro instance over a minimal HTTP/JSON API for
+// prototypes and tests.
+//
+// The Nitro instance should use the nitro.CompareKV comparator.
+//
+//	GET    /kv/<key>[?snapshot=<id>]   returns the value
+//	PUT    /kv/<key>                   stores the request body as value
+//	DELETE /kv/<key>                   deletes the key
+//	GET    /scan?start=&end=&limit=&snapshot=
+//	                                   returns a JSON array of {key, value}
+//	POST   /snapshots                  creates a snapshot and returns {id}
+//	DELETE /snapshots/<id>             releases a snapshot
+//
+// Mutations are serialized through a single Nitro writer. Reads without an
+// explicit snapshot observe all mutations acknowledged before the request.
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/t3rm1n4l/nitro"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultScanLimit = 1000
+
+// KV is a key-value pair returned by scans
+type KV struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// Server implements http.Handler for a Nitro instance
+type Server struct {
+	db *nitro.Nitro
+	w  *nitro.Writer
+
+	sync.Mutex
+	current *nitro.Snapshot
+	dirty   bool
+	snaps   map[uint64]*nitro.Snapshot
+	nextID  uint64
+
+	mux *http.ServeMux
+}
+
+// New creates a server for the Nitro instance
+func New(db *nitro.Nitro) *Server {
+	s := &Server{
+		db:    db,
+		w:     db.NewWriter(),
+		dirty: true,
+		snaps: make(map[uint64]*nitro.Snapshot),
+		mux:   http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/kv/", s.handleKV)
+	s.mux.HandleFunc("/scan", s.handleScan)
+	s.mux.HandleFunc("/snapshots", s.handleSnapshots)
+	s.mux.HandleFunc("/snapshots/", s.handleSnapshots)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close releases all snapshots held by the server.
+// The Nitro instance should be closed by the caller afterwards.
+func (s *Server) Close() {
+	s.Lock()
+	defer s.Unlock()
+
+	for id, snap := range s.snaps {
+		snap.Close()
+		delete(s.snaps, id)
+	}
+
+	if s.current != nil {
+		s.current.Close()
+		s.current = nil
+	}
+}
+
+// readSnapshot returns an opened snapshot which should be closed by the caller
+func (s *Server) readSnapshot(r *http.Request) (*nitro.Snapshot, int) {
+	s.Lock()
+	defer s.Unlock()
+
+	if v := r.URL.Query().Get("snapshot"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		snap, ok := s.snaps[id]
+		if err != nil || !ok {
+			return nil, http.StatusNotFound
+		}
+		snap.Open()
+		return snap, 0
+	}
+
+	if s.dirty {
+		snap, err := s.db.NewSnapshot()
+		if err != nil {
+			return nil, http.StatusInternalServerError
+		}
+
+		if s.current != nil {
+			s.current.Close()
+		}
+		s.current = snap
+		s.dirty = false
+	}
+
+	s.current.Open()
+	return s.current, 0
+}
+
+func (s *Server) lookup(snap *nitro.Snapshot, key []byte) ([]byte, bool) {
+	itr := snap.NewIterator()
+	defer itr.Close()
+
+	itr.Seek(nitro.KVToBytes(key, nil))
+	if itr.Valid() {
+		if k, v := nitro.KVFromBytes(itr.Get()); bytes.Equal(k, key) {
+			return append([]byte(nil), v...), true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *Server) handleKV(w http.ResponseWriter, r *http.Request) {
+	key := []byte(strings.TrimPrefix(r.URL.Path, "/kv/"))
+	if len(key) == 0 {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		snap, status := s.readSnapshot(r)
+		if status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		defer snap.Close()
+
+		v, ok := s.lookup(snap, key)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(v)
+
+	case "PUT":
+		v, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.Lock()
+		s.w.Delete(nitro.KVToBytes(key, nil))
+		s.w.Put(nitro.KVToBytes(key, v))
+		s.dirty = true
+		s.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	case "DELETE":
+		s.Lock()
+		found := s.w.Delete(nitro.KVToBytes(key, nil))
+		s.dirty = true
+		s.Unlock()
+
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	limit := defaultScanLimit
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	snap, status := s.readSnapshot(r)
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	defer snap.Close()
+
+	itr := snap.NewIterator()
+	defer itr.Close()
+
+	if start := q.Get("start"); start != "" {
+		itr.Seek(nitro.KVToBytes([]byte(start), nil))
+	} else {
+		itr.SeekFirst()
+	}
+
+	end := []byte(q.Get("end"))
+	res := []KV{}
+	for ; itr.Valid() && len(res) < limit; itr.Next() {
+		k, v := nitro.KVFromBytes(itr.Get())
+		if len(end) > 0 && bytes.Compare(k, end) >= 0 {
+			break
+		}
+		res = append(res, KV{Key: append([]byte(nil), k...), Value: append([]byte(nil), v...)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+func (s *Server) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch r.Method {
+	case "POST":
+		snap, err := s.db.NewSnapshot()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		s.nextID++
+		s.snaps[s.nextID] = snap
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]uint64{"id": s.nextID})
+
+	case "DELETE":
+		id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, "/snapshots/"), 10, 64)
+		snap, ok := s.snaps[id]
+		if err != nil || !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		snap.Close()
+		delete(s.snaps, id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
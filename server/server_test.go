@@ -0,0 +1,81 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/t3rm1n4l/nitro"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func do(t *testing.T, method, url, body string) (int, string) {
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	bs, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(bs)
+}
+
+func TestServer(t *testing.T) {
+	cfg := nitro.DefaultConfig()
+	cfg.SetKeyComparator(nitro.CompareKV)
+	db := nitro.NewWithConfig(cfg)
+	defer db.Close()
+
+	s := New(db)
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	for i := 0; i < 10; i++ {
+		if code, _ := do(t, "PUT", fmt.Sprintf("%s/kv/key-%d", ts.URL, i), fmt.Sprintf("v%d", i)); code != http.StatusNoContent {
+			t.Fatalf("Unexpected status %d", code)
+		}
+	}
+
+	_, body := do(t, "POST", ts.URL+"/snapshots", "")
+	var snap map[string]uint64
+	json.Unmarshal([]byte(body), &snap)
+
+	do(t, "PUT", ts.URL+"/kv/key-1", "updated")
+	do(t, "DELETE", ts.URL+"/kv/key-2", "")
+
+	if code, v := do(t, "GET", ts.URL+"/kv/key-1", ""); code != http.StatusOK || v != "updated" {
+		t.Errorf("Unexpected response %d %s", code, v)
+	}
+
+	if code, _ := do(t, "GET", ts.URL+"/kv/key-2", ""); code != http.StatusNotFound {
+		t.Errorf("Expected not found, got %d", code)
+	}
+
+	url := fmt.Sprintf("%s/kv/key-1?snapshot=%d", ts.URL, snap["id"])
+	if code, v := do(t, "GET", url, ""); code != http.StatusOK || v != "v1" {
+		t.Errorf("Unexpected snapshot response %d %s", code, v)
+	}
+
+	var kvs []KV
+	_, body = do(t, "GET", ts.URL+"/scan?start=key-1&end=key-5&limit=3", "")
+	json.Unmarshal([]byte(body), &kvs)
+	if len(kvs) != 3 || string(kvs[0].Key) != "key-1" || string(kvs[1].Key) != "key-3" {
+		t.Errorf("Unexpected scan result %s", body)
+	}
+
+	if code, _ := do(t, "DELETE", fmt.Sprintf("%s/snapshots/%d", ts.URL, snap["id"]), ""); code != http.StatusNoContent {
+		t.Errorf("Unexpected status %d", code)
+	}
+}
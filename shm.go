@@ -0,0 +1,269 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"io"
+	"os"
+	"sort"
+)
+
+// ErrCorruptShared means the shared snapshot file is not valid
+var ErrCorruptShared = nerrors.New(nerrors.ErrCorrupt, "Invalid shared snapshot file")
+
+/*
+* Shared snapshot format (little endian):
+*   [magic "NITROSHM"][version (4 bytes)][reserved (4 bytes)][count (8 bytes)]
+*   [item offsets (count * 8 bytes)]
+*   [item length (4 bytes)][item data] ...
+*
+* Items are stored in the snapshot order, so that a consumer can binary search
+* the mapped file without decoding it.
+* */
+
+const (
+	shmMagic      = "NITROSHM"
+	shmVersion    = 1
+	shmHeaderSize = 24
+)
+
+// itemArray is a sorted, immutable array of items encoded in a byte slice
+type itemArray struct {
+	data  []byte
+	count int
+	cmp   KeyCompare
+}
+
+func newItemArray(data []byte, cmp KeyCompare) (*itemArray, error) {
+	if len(data) < shmHeaderSize || string(data[0:8]) != shmMagic ||
+		binary.LittleEndian.Uint32(data[8:12]) != shmVersion {
+		return nil, ErrCorruptShared
+	}
+
+	count := binary.LittleEndian.Uint64(data[16:24])
+	if count > uint64(len(data)-shmHeaderSize)/8 {
+		return nil, ErrCorruptShared
+	}
+
+	a := &itemArray{data: data, count: int(count), cmp: cmp}
+	if a.cmp == nil {
+		a.cmp = defaultKeyCmp
+	}
+
+	// Validate item bounds upfront so that lookups can not fault.
+	// The checks avoid additions which may overflow for hostile offsets.
+	end, size := uint64(shmHeaderSize+8*a.count), uint64(len(data))
+	for i := 0; i < a.count; i++ {
+		off := binary.LittleEndian.Uint64(data[shmHeaderSize+8*i:])
+		if off < end || off > size-4 ||
+			uint64(binary.LittleEndian.Uint32(data[off:])) > size-off-4 {
+			return nil, ErrCorruptShared
+		}
+	}
+
+	return a, nil
+}
+
+func (a *itemArray) item(i int) []byte {
+	off := binary.LittleEndian.Uint64(a.data[shmHeaderSize+8*i:])
+	l := uint64(binary.LittleEndian.Uint32(a.data[off:]))
+	return a.data[off+4 : off+4+l : off+4+l]
+}
+
+// search returns the index of the first item greater than or equal to key
+func (a *itemArray) search(key []byte) int {
+	return sort.Search(a.count, func(i int) bool {
+		return a.cmp(a.item(i), key) >= 0
+	})
+}
+
+// lookup returns the item with the key
+func (a *itemArray) lookup(key []byte) ([]byte, bool) {
+	if i := a.search(key); i < a.count {
+		if bs := a.item(i); a.cmp(bs, key) == 0 {
+			return bs, true
+		}
+	}
+	return nil, false
+}
+
+// ArrayIterator iterates a sorted item array. It is safe to use concurrently
+// with other iterators of the same array.
+type ArrayIterator struct {
+	a   *itemArray
+	pos int
+}
+
+// SeekFirst moves the cursor to the first item
+func (it *ArrayIterator) SeekFirst() {
+	it.pos = 0
+}
+
+// Seek moves the cursor to the item or the next bigger one
+func (it *ArrayIterator) Seek(bs []byte) {
+	it.pos = it.a.search(bs)
+}
+
+// Valid returns false when the iterator has reached the end
+func (it *ArrayIterator) Valid() bool {
+	return it.pos < it.a.count
+}
+
+// Get returns the current item. It should not be modified.
+func (it *ArrayIterator) Get() []byte {
+	return it.a.item(it.pos)
+}
+
+// Next moves the cursor to the next item
+func (it *ArrayIterator) Next() {
+	it.pos++
+}
+
+// sizeItemArray returns the number of items and the size of the item
+// section of the array
+func sizeItemArray(itr *Iterator) (count, size uint64) {
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		count++
+		size += 4 + uint64(len(itr.Get()))
+	}
+	return
+}
+
+func writeItemArray(w io.Writer, itr *Iterator, count uint64) error {
+	var hdr [shmHeaderSize]byte
+	copy(hdr[0:8], shmMagic)
+	binary.LittleEndian.PutUint32(hdr[8:12], shmVersion)
+	binary.LittleEndian.PutUint64(hdr[16:24], count)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+
+	var buf [8]byte
+	off := uint64(shmHeaderSize) + 8*count
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		binary.LittleEndian.PutUint64(buf[:], off)
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+		off += 4 + uint64(len(itr.Get()))
+	}
+
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		bs := itr.Get()
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(bs)))
+		if _, err := w.Write(buf[:4]); err != nil {
+			return err
+		}
+		if _, err := w.Write(bs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// buildItemArray copies the snapshot items into an in-memory item array
+func (m *Nitro) buildItemArray(snap *Snapshot) (*itemArray, error) {
+	itr := snap.NewIterator()
+	if itr == nil {
+		return nil, ErrSnapshotClosed
+	}
+	defer itr.Close()
+
+	count, size := sizeItemArray(itr)
+	buf := bytes.NewBuffer(make([]byte, 0, shmHeaderSize+8*count+size))
+	writeItemArray(buf, itr, count)
+	return &itemArray{data: buf.Bytes(), count: int(count), cmp: m.keyCmp}, nil
+}
+
+// ExportSharedSnapshot writes the snapshot items to the file in a format
+// which can be mapped by other processes using OpenSharedSnapshot(). The path
+// is typically located on a tmpfs such as /dev/shm. The file is written
+// elsewhere and renamed into place, so that readers never see a partial file.
+// This is an experimental API.
+func (m *Nitro) ExportSharedSnapshot(path string, snap *Snapshot) error {
+	itr := snap.NewIterator()
+	if itr == nil {
+		return ErrSnapshotClosed
+	}
+	defer itr.Close()
+	count, _ := sizeItemArray(itr)
+
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	if err = writeItemArray(w, itr, count); err == nil {
+		err = w.Flush()
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// SharedSnapshot is a read-only mapping of a snapshot exported by
+// ExportSharedSnapshot(). Items are read directly from the mapping.
+type SharedSnapshot struct {
+	mapping []byte
+	*itemArray
+}
+
+// OpenSharedSnapshot maps a shared snapshot file. The key comparator should
+// be the one used by the exporting Nitro instance. A nil comparator uses
+// the default bytes comparator.
+func OpenSharedSnapshot(path string, cmp KeyCompare) (*SharedSnapshot, error) {
+	mapping, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := newItemArray(mapping, cmp)
+	if err != nil {
+		unmapFile(mapping)
+		return nil, err
+	}
+
+	return &SharedSnapshot{mapping: mapping, itemArray: a}, nil
+}
+
+// Count returns the number of items in the snapshot
+func (s *SharedSnapshot) Count() int {
+	return s.count
+}
+
+// NewIterator creates an iterator for the shared snapshot
+func (s *SharedSnapshot) NewIterator() *ArrayIterator {
+	return &ArrayIterator{a: s.itemArray}
+}
+
+// Close unmaps the snapshot. Items returned by its iterators should not be
+// used after Close.
+func (s *SharedSnapshot) Close() error {
+	return unmapFile(s.mapping)
+}
@@ -0,0 +1,26 @@
+//go:build windows
+// +build windows
+
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"io/ioutil"
+)
+
+// Memory mapping is not supported on windows, the file is read instead
+func mapFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+func unmapFile(b []byte) error {
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Maximum number of distinct prefixes tracked per writer
+const keySamplerCapacity = 64
+
+// KeySampling configures sampling of inserted keys for the key skew report
+type KeySampling struct {
+	// Every n-th Put() of a writer is sampled
+	Every int
+
+	// Keys are grouped by their leading PrefixLen bytes
+	PrefixLen int
+
+	// Key optionally extracts the key from item bytes. For example, items
+	// encoded using KVToBytes() can be sampled by their key.
+	Key func([]byte) []byte
+}
+
+// SetKeySampling enables sampling of keys inserted by writers. It is disabled
+// by default.
+func (cfg *Config) SetKeySampling(s KeySampling) {
+	cfg.keySampling = s
+}
+
+// HotPrefix is a frequently inserted key prefix
+type HotPrefix struct {
+	Prefix   []byte
+	Count    uint64
+	Fraction float64
+}
+
+// KeySkewReport describes the distribution of sampled keys
+type KeySkewReport struct {
+	Samples uint64
+
+	// Fraction of samples which were greater than the previous sample of the
+	// same writer. A value close to 1 means monotonically increasing keys.
+	Increasing float64
+
+	// Most frequent prefixes in the decreasing order of count. Counts are
+	// approximate once a writer has seen more distinct prefixes than it
+	// can track.
+	HotPrefixes []HotPrefix
+}
+
+func (r KeySkewReport) String() string {
+	str := fmt.Sprintf(
+		"samples    = %d\n"+
+			"increasing = %.4f\n"+
+			"hot_prefixes:\n",
+		r.Samples, r.Increasing)
+
+	for _, p := range r.HotPrefixes {
+		str += fmt.Sprintf("%q => %d (%.4f)\n", p.Prefix, p.Count, p.Fraction)
+	}
+
+	return str
+}
+
+// keySampler tracks the hottest prefixes using the space-saving algorithm
+type keySampler struct {
+	cfg  KeySampling
+	puts int
+
+	sync.Mutex
+	samples    uint64
+	increasing uint64
+	last       []byte
+	prefixes   map[string]uint64
+}
+
+func newKeySampler(cfg KeySampling) *keySampler {
+	if cfg.Every <= 0 || cfg.PrefixLen <= 0 {
+		return nil
+	}
+
+	return &keySampler{cfg: cfg, prefixes: make(map[string]uint64)}
+}
+
+func (w *Writer) sampleKey(bs []byte) {
+	ks := w.sampler
+	if ks.puts++; ks.puts < ks.cfg.Every {
+		return
+	}
+	ks.puts = 0
+
+	key := bs
+	if ks.cfg.Key != nil {
+		key = ks.cfg.Key(bs)
+	}
+
+	prefix := key
+	if len(prefix) > ks.cfg.PrefixLen {
+		prefix = prefix[:ks.cfg.PrefixLen]
+	}
+
+	ks.Lock()
+	defer ks.Unlock()
+
+	if ks.last != nil && w.keyCmp(bs, ks.last) > 0 {
+		ks.increasing++
+	}
+	ks.last = append(ks.last[:0], bs...)
+	ks.samples++
+
+	if _, ok := ks.prefixes[string(prefix)]; ok || len(ks.prefixes) < keySamplerCapacity {
+		ks.prefixes[string(prefix)]++
+		return
+	}
+
+	// Replace the least frequent prefix and inherit its count
+	var minPrefix string
+	var minCount uint64
+	for p, c := range ks.prefixes {
+		if minPrefix == "" || c < minCount {
+			minPrefix, minCount = p, c
+		}
+	}
+	delete(ks.prefixes, minPrefix)
+	ks.prefixes[string(prefix)] = minCount + 1
+}
+
+// KeySkewReport returns the `k` hottest key prefixes sampled across all
+// writers. Key sampling should be enabled using Config.SetKeySampling().
+func (m *Nitro) KeySkewReport(k int) KeySkewReport {
+	var r KeySkewReport
+	var increasing, pairs uint64
+	counts := make(map[string]uint64)
+
+	for w := m.wlist; w != nil; w = w.next {
+		ks := w.sampler
+		if ks == nil {
+			continue
+		}
+
+		ks.Lock()
+		r.Samples += ks.samples
+		increasing += ks.increasing
+		if ks.samples > 0 {
+			pairs += ks.samples - 1
+		}
+		for p, c := range ks.prefixes {
+			counts[p] += c
+		}
+		ks.Unlock()
+	}
+
+	if pairs > 0 {
+		r.Increasing = float64(increasing) / float64(pairs)
+	}
+
+	for p, c := range counts {
+		r.HotPrefixes = append(r.HotPrefixes, HotPrefix{
+			Prefix:   []byte(p),
+			Count:    c,
+			Fraction: float64(c) / float64(r.Samples),
+		})
+	}
+
+	sort.Slice(r.HotPrefixes, func(i, j int) bool {
+		a, b := r.HotPrefixes[i], r.HotPrefixes[j]
+		return a.Count > b.Count || a.Count == b.Count && string(a.Prefix) < string(b.Prefix)
+	})
+
+	if len(r.HotPrefixes) > k {
+		r.HotPrefixes = r.HotPrefixes[:k]
+	}
+
+	return r
+}
@@ -131,7 +131,7 @@ func (ab *AccessBarrier) doCleanup() {
 			return
 		}
 
-		ab.freeSeqno++
+		atomic.AddUint64(&ab.freeSeqno, 1)
 		ab.callb(bs.objectRef)
 		ab.freeq.DeleteNode(node, CompareBS, buf2, &ab.freeq.Stats)
 	}
@@ -187,10 +187,15 @@ func (ab *AccessBarrier) FlushSession(ref unsafe.Pointer) {
 		atomic.CompareAndSwapPointer(&ab.session, bsPtr, newBsPtr)
 		bs := (*BarrierSession)(bsPtr)
 		bs.objectRef = ref
-		ab.activeSeqno++
-		bs.seqno = ab.activeSeqno
+		bs.seqno = atomic.AddUint64(&ab.activeSeqno, 1)
 
 		atomic.AddInt32(bs.liveCount, barrierFlushOffset+1)
 		ab.Release(bs)
 	}
 }
+
+// Sessions returns the number of barrier sessions closed and the number of
+// closed sessions whose destructor has been called
+func (ab *AccessBarrier) Sessions() (closed, freed uint64) {
+	return atomic.LoadUint64(&ab.activeSeqno), atomic.LoadUint64(&ab.freeSeqno)
+}
@@ -12,7 +12,6 @@
 package skiplist
 
 import (
-	"reflect"
 	"sync/atomic"
 	"unsafe"
 )
@@ -26,11 +25,7 @@ type Node struct {
 }
 
 func (n *Node) nextArray() (s []unsafe.Pointer) {
-	hdr := (*reflect.SliceHeader)(unsafe.Pointer(&s))
-	hdr.Data = uintptr(n.next)
-	hdr.Len = n.Level() + 1
-	hdr.Cap = hdr.Len
-	return
+	return unsafe.Slice((*unsafe.Pointer)(n.next), n.Level()+1)
 }
 
 // Level returns the level of a node in the skiplist
@@ -274,12 +274,7 @@ func init() {
 // Fill free blocks with a const
 // This can help debugging of memory reclaimer bugs
 func debugMarkFree(n *Node) {
-	var block []byte
 	l := int(nodeTypes[n.level].Size())
-	sh := (*reflect.SliceHeader)(unsafe.Pointer(&block))
-	sh.Data = uintptr(unsafe.Pointer(n))
-	sh.Len = l
-	sh.Cap = l
-
+	block := unsafe.Slice((*byte)(unsafe.Pointer(n)), l)
 	copy(block, freeBlockContent)
 }
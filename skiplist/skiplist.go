@@ -178,6 +178,7 @@ func (s *Skiplist) NewLevel(randFn func() float32) int {
 }
 
 func (s *Skiplist) helpDelete(level int, prev, curr, next *Node, sts *Stats) bool {
+	yield(YieldHelpDelete)
 	success := prev.dcasNext(level, curr, next, false, false)
 	if success && level == 0 {
 		sts.AddInt64(&sts.softDeletes, -1)
@@ -192,6 +193,7 @@ func (s *Skiplist) findPath(itm unsafe.Pointer, cmp CompareFn,
 	var cmpVal = 1
 
 retry:
+	yield(YieldFindPath)
 	prev := s.head
 	level := int(atomic.LoadInt32(&s.level))
 	for i := level; i >= 0; i-- {
@@ -269,6 +271,7 @@ retry:
 	}
 
 	// Now node is part of the skiplist
+	yield(YieldInsertLink)
 	if !buf.preds[0].dcasNext(0, buf.succs[0], x, false, false) {
 		sts.AddUint64(&sts.insertConflicts, 1)
 		goto retry
@@ -288,6 +291,7 @@ retry:
 				goto finished
 			}
 
+			yield(YieldIndexLink)
 			if buf.preds[i].dcasNext(i, next, x, false, false) {
 				break fixThisLevel
 			}
@@ -310,6 +314,7 @@ func (s *Skiplist) softDelete(delNode *Node, sts *Stats) bool {
 	for i := targetLevel; i >= 0; i-- {
 		next, deleted := delNode.getNext(i)
 		for !deleted {
+			yield(YieldSoftDelete)
 			if delNode.dcasNext(i, next, next, false, true) && i == 0 {
 				sts.AddInt64(&sts.softDeletes, 1)
 				marked = true
@@ -0,0 +1,33 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package skiplist
+
+// YieldPoint identifies a step of a lock-free skiplist operation at which a
+// concurrent operation can interleave
+type YieldPoint int
+
+const (
+	// YieldFindPath is reached before every traversal attempt of findPath
+	YieldFindPath YieldPoint = iota
+	// YieldHelpDelete is reached before unlinking a marked node
+	YieldHelpDelete
+	// YieldInsertLink is reached before linking a new node at level 0
+	YieldInsertLink
+	// YieldIndexLink is reached before linking a new node at an index level
+	YieldIndexLink
+	// YieldSoftDelete is reached before marking a node as deleted
+	YieldSoftDelete
+)
+
+var yieldPointNames = []string{"find-path", "help-delete", "insert-link", "index-link", "soft-delete"}
+
+func (p YieldPoint) String() string {
+	return yieldPointNames[p]
+}
@@ -0,0 +1,138 @@
+//go:build nitro_sim
+// +build nitro_sim
+
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package skiplist
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+/*
+* Deterministic simulation mode
+*
+* A Scheduler runs a set of tasks as goroutines, but only one of them is
+* allowed to make progress at a time. Whenever the running task reaches a
+* yield point, the scheduler picks the next task to run using a seeded random
+* generator. Hence, the interleaving of skiplist operations performed by the
+* tasks is a function of the seed and a failure can be reproduced by running
+* the same tasks with the same seed.
+*
+* Tasks should only block at yield points. While a scheduler is running, the
+* skiplists should not be accessed from goroutines other than its tasks.
+* */
+
+var activeScheduler unsafe.Pointer
+
+func yield(p YieldPoint) {
+	if s := (*Scheduler)(atomic.LoadPointer(&activeScheduler)); s != nil {
+		s.yield(p)
+	}
+}
+
+// TraceEvent records a task reaching a yield point
+type TraceEvent struct {
+	Task  int
+	Point YieldPoint
+}
+
+type simTask struct {
+	id   int
+	fn   func()
+	turn chan struct{}
+}
+
+// Scheduler deterministically interleaves tasks at the skiplist yield points
+type Scheduler struct {
+	rand  *rand.Rand
+	tasks []*simTask
+
+	// Accessed only by the running task
+	runnable []*simTask
+	current  *simTask
+	trace    []TraceEvent
+
+	wg sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler which interleaves tasks as per the seed
+func NewScheduler(seed int64) *Scheduler {
+	return &Scheduler{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Go adds a task to be run by Run()
+func (s *Scheduler) Go(fn func()) {
+	s.tasks = append(s.tasks, &simTask{id: len(s.tasks), fn: fn, turn: make(chan struct{})})
+}
+
+// Run executes all the tasks until completion. Only one scheduler can run at
+// a time.
+func (s *Scheduler) Run() {
+	if !atomic.CompareAndSwapPointer(&activeScheduler, nil, unsafe.Pointer(s)) {
+		panic("skiplist: another scheduler is running")
+	}
+	defer atomic.StorePointer(&activeScheduler, nil)
+
+	s.runnable = append([]*simTask(nil), s.tasks...)
+	for _, t := range s.tasks {
+		s.wg.Add(1)
+		go s.runTask(t)
+	}
+
+	if len(s.runnable) > 0 {
+		s.switchTo(s.pick())
+	}
+	s.wg.Wait()
+}
+
+// Trace returns the sequence of yield points reached by the tasks
+func (s *Scheduler) Trace() []TraceEvent {
+	return s.trace
+}
+
+func (s *Scheduler) runTask(t *simTask) {
+	defer s.wg.Done()
+	<-t.turn
+	t.fn()
+
+	// Hand over to the remaining tasks
+	for i, r := range s.runnable {
+		if r == t {
+			s.runnable = append(s.runnable[:i], s.runnable[i+1:]...)
+			break
+		}
+	}
+
+	if len(s.runnable) > 0 {
+		s.switchTo(s.pick())
+	}
+}
+
+func (s *Scheduler) pick() *simTask {
+	return s.runnable[s.rand.Intn(len(s.runnable))]
+}
+
+func (s *Scheduler) switchTo(t *simTask) {
+	s.current = t
+	t.turn <- struct{}{}
+}
+
+func (s *Scheduler) yield(p YieldPoint) {
+	curr := s.current
+	s.trace = append(s.trace, TraceEvent{Task: curr.id, Point: p})
+	if next := s.pick(); next != curr {
+		s.switchTo(next)
+		<-curr.turn
+	}
+}
@@ -0,0 +1,66 @@
+//go:build nitro_sim
+// +build nitro_sim
+
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package skiplist
+
+import "testing"
+import "fmt"
+import "math/rand"
+import "reflect"
+
+func runSimulation(t *testing.T, seed int64) []TraceEvent {
+	s := New()
+	sched := NewScheduler(seed)
+	for w := 0; w < 4; w++ {
+		w := w
+		sched.Go(func() {
+			buf := s.MakeBuf()
+			rnd := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < 100; i++ {
+				itm := NewByteKeyItem([]byte(fmt.Sprintf("%010d", i*4+w)))
+				s.Insert2(itm, CompareBytes, nil, buf, rnd.Float32, &s.Stats)
+			}
+
+			for i := 0; i < 100; i += 2 {
+				itm := NewByteKeyItem([]byte(fmt.Sprintf("%010d", i*4+(w+1)%4)))
+				s.Delete(itm, CompareBytes, buf, &s.Stats)
+			}
+		})
+	}
+	sched.Run()
+
+	buf := s.MakeBuf()
+	itr := s.NewIterator(CompareBytes, buf)
+	count := 0
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		count++
+	}
+	itr.Close()
+
+	if count != 200 {
+		t.Errorf("Expected 200 items, got %d", count)
+	}
+
+	return sched.Trace()
+}
+
+func TestSchedulerDeterminism(t *testing.T) {
+	trace1 := runSimulation(t, 42)
+	trace2 := runSimulation(t, 42)
+	if !reflect.DeepEqual(trace1, trace2) {
+		t.Errorf("Expected identical interleavings for the same seed")
+	}
+
+	if trace3 := runSimulation(t, 43); reflect.DeepEqual(trace1, trace3) {
+		t.Errorf("Expected different interleavings for different seeds")
+	}
+}
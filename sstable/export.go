@@ -0,0 +1,108 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package sstable
+
+import (
+	"fmt"
+	"github.com/t3rm1n4l/nitro"
+	"os"
+	"path/filepath"
+)
+
+// KVFunc splits Nitro item bytes into the key and value of a table entry
+type KVFunc func([]byte) (k, v []byte)
+
+// ExportOptions configures a snapshot export
+type ExportOptions struct {
+	// KV maps items to table entries. By default, nitro.KVFromBytes is used.
+	KV KVFunc
+
+	// MaxFileSize starts a new table file once the current file reaches
+	// this size. A zero value writes a single file.
+	MaxFileSize int64
+
+	// BlockSize is the target data block size
+	BlockSize int
+}
+
+// Export writes all items of a snapshot into table files named
+// 000001.sst, 000002.sst... in dir and returns the file paths.
+// Snapshot iteration order should match the bytewise ordering of the
+// exported keys.
+func Export(snap *nitro.Snapshot, dir string, opts ExportOptions) (files []string, err error) {
+	var f *os.File
+	var tw *Writer
+
+	if opts.KV == nil {
+		opts.KV = nitro.KVFromBytes
+	}
+
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = DefaultBlockSize
+	}
+
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	finish := func() error {
+		err := tw.Close()
+		if err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		tw, f = nil, nil
+		return err
+	}
+
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	itr := snap.NewIterator()
+	if itr == nil {
+		return nil, nitro.ErrSnapshotClosed
+	}
+	defer itr.Close()
+
+	for itr.SeekFirst(); itr.Valid(); itr.Next() {
+		if tw == nil {
+			path := filepath.Join(dir, fmt.Sprintf("%06d.sst", len(files)+1))
+			if f, err = os.Create(path); err != nil {
+				return nil, err
+			}
+			tw = NewWriterSize(f, opts.BlockSize)
+			files = append(files, path)
+		}
+
+		k, v := opts.KV(itr.Get())
+		if err = tw.Add(k, v); err != nil {
+			return nil, err
+		}
+
+		if opts.MaxFileSize > 0 && tw.Size() >= opts.MaxFileSize {
+			if err = finish(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if tw != nil {
+		if err = finish(); err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
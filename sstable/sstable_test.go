@@ -0,0 +1,140 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package sstable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/t3rm1n4l/nitro"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+type kv struct {
+	k, v string
+}
+
+func readBlock(t *testing.T, file []byte, h blockHandle) []byte {
+	contents := file[h.offset : h.offset+h.size]
+	trailer := file[h.offset+h.size : h.offset+h.size+blockTrailerLen]
+	if trailer[0] != noCompression {
+		t.Fatalf("Unexpected compression type %d", trailer[0])
+	}
+	if binary.LittleEndian.Uint32(trailer[1:]) != maskedCRC(contents, trailer[:1]) {
+		t.Fatalf("Block checksum mismatch at %d", h.offset)
+	}
+	return contents
+}
+
+func decodeBlock(t *testing.T, block []byte) (entries []kv) {
+	nrestarts := int(binary.LittleEndian.Uint32(block[len(block)-4:]))
+	data := block[:len(block)-4-4*nrestarts]
+	var key []byte
+	for len(data) > 0 {
+		shared, n1 := binary.Uvarint(data)
+		nonShared, n2 := binary.Uvarint(data[n1:])
+		vlen, n3 := binary.Uvarint(data[n1+n2:])
+		data = data[n1+n2+n3:]
+		key = append(key[:shared], data[:nonShared]...)
+		entries = append(entries, kv{string(key), string(data[nonShared : nonShared+vlen])})
+		data = data[nonShared+vlen:]
+	}
+	return
+}
+
+func decodeHandle(bs []byte) (blockHandle, int) {
+	off, n1 := binary.Uvarint(bs)
+	sz, n2 := binary.Uvarint(bs[n1:])
+	return blockHandle{off, sz}, n1 + n2
+}
+
+func readTable(t *testing.T, path string) (entries []kv) {
+	file, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	footer := file[len(file)-footerLen:]
+	if binary.LittleEndian.Uint64(footer[footerLen-8:]) != tableMagic {
+		t.Fatalf("Bad table magic")
+	}
+
+	_, n := decodeHandle(footer)
+	indexH, _ := decodeHandle(footer[n:])
+	for _, ie := range decodeBlock(t, readBlock(t, file, indexH)) {
+		h, _ := decodeHandle([]byte(ie.v))
+		for _, e := range decodeBlock(t, readBlock(t, file, h)) {
+			ikey := e.k
+			if ie.k < ikey {
+				t.Errorf("Index key %q is smaller than block key %q", ie.k, ikey)
+			}
+			if binary.LittleEndian.Uint64([]byte(ikey[len(ikey)-8:])) != typeValue {
+				t.Errorf("Unexpected internal key trailer for %q", ikey)
+			}
+			entries = append(entries, kv{ikey[:len(ikey)-8], e.v})
+		}
+	}
+
+	return
+}
+
+func TestExport(t *testing.T) {
+	dir := "sst.export"
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	cfg := nitro.DefaultConfig()
+	cfg.SetKeyComparator(nitro.CompareKV)
+	db := nitro.NewWithConfig(cfg)
+	defer db.Close()
+
+	n := 10000
+	w := db.NewWriter()
+	for i := 0; i < n; i++ {
+		w.Put(nitro.KVToBytes([]byte(fmt.Sprintf("key-%06d", i)), []byte(fmt.Sprintf("value-%d", i))))
+	}
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+
+	files, err := Export(snap, dir, ExportOptions{MaxFileSize: 64 * 1024})
+	if err != nil {
+		t.Fatalf("Expected no error. got=%v", err)
+	}
+
+	if len(files) < 2 {
+		t.Errorf("Expected multiple files, got %v", files)
+	}
+
+	i := 0
+	for _, f := range files {
+		for _, e := range readTable(t, f) {
+			if exp := fmt.Sprintf("key-%06d", i); e.k != exp {
+				t.Errorf("Expected %s, got %s", exp, e.k)
+			}
+			if exp := fmt.Sprintf("value-%d", i); e.v != exp {
+				t.Errorf("Expected %s, got %s", exp, e.v)
+			}
+			i++
+		}
+	}
+
+	if i != n {
+		t.Errorf("Expected %d entries, got %d", n, i)
+	}
+}
+
+func TestWriterUnsorted(t *testing.T) {
+	tw := NewWriter(ioutil.Discard)
+	tw.Add([]byte("b"), nil)
+	if err := tw.Add([]byte("a"), nil); err != ErrUnsortedKeys {
+		t.Errorf("Expected ErrUnsortedKeys, got %v", err)
+	}
+}
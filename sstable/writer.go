@@ -0,0 +1,259 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package sstable exports Nitro snapshots as LevelDB table files.
+//
+// The files use the LevelDB table format with uncompressed blocks, bytewise
+// key ordering and no filter block. Keys are written as internal keys with
+// sequence number zero, which makes the files readable by LevelDB and by the
+// RocksDB block based table reader (legacy format).
+package sstable
+
+import (
+	"bytes"
+	"encoding/binary"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// DefaultBlockSize is the target uncompressed size of a data block
+	DefaultBlockSize = 4096
+
+	restartInterval = 16
+	blockTrailerLen = 5
+	noCompression   = 0
+	typeValue       = 1
+	handleMaxLen    = 20
+	footerLen       = 2*handleMaxLen + 8
+	tableMagic      = 0xdb4775248b80fb57
+	crcMaskDelta    = 0xa282ead8
+)
+
+var (
+	// ErrUnsortedKeys means keys were not added in strictly increasing order
+	ErrUnsortedKeys = nerrors.New(nerrors.ErrInvalidConfig, "sstable: keys are not in sorted order")
+	// ErrClosed means the writer has been closed
+	ErrClosed = nerrors.New(nerrors.ErrClosed, "sstable: writer is closed")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+func maskedCRC(bs ...[]byte) uint32 {
+	var c uint32
+	for _, b := range bs {
+		c = crc32.Update(c, crcTable, b)
+	}
+	return ((c >> 15) | (c << 17)) + crcMaskDelta
+}
+
+type blockHandle struct {
+	offset, size uint64
+}
+
+func (h blockHandle) encode(dst []byte) []byte {
+	var buf [2 * binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], h.offset)
+	n += binary.PutUvarint(buf[n:], h.size)
+	return append(dst, buf[:n]...)
+}
+
+type blockBuilder struct {
+	buf      bytes.Buffer
+	restarts []uint32
+	counter  int
+	lastKey  []byte
+	entries  int
+}
+
+func (b *blockBuilder) reset() {
+	b.buf.Reset()
+	b.restarts = append(b.restarts[:0], 0)
+	b.counter = 0
+	b.lastKey = b.lastKey[:0]
+	b.entries = 0
+}
+
+func (b *blockBuilder) add(key, value []byte) {
+	shared := 0
+	if b.counter < restartInterval {
+		for shared < len(key) && shared < len(b.lastKey) && key[shared] == b.lastKey[shared] {
+			shared++
+		}
+	} else {
+		b.restarts = append(b.restarts, uint32(b.buf.Len()))
+		b.counter = 0
+	}
+
+	var hdr [3 * binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(hdr[:], uint64(shared))
+	n += binary.PutUvarint(hdr[n:], uint64(len(key)-shared))
+	n += binary.PutUvarint(hdr[n:], uint64(len(value)))
+	b.buf.Write(hdr[:n])
+	b.buf.Write(key[shared:])
+	b.buf.Write(value)
+
+	b.lastKey = append(b.lastKey[:0], key...)
+	b.counter++
+	b.entries++
+}
+
+func (b *blockBuilder) estimatedSize() int {
+	return b.buf.Len() + 4*len(b.restarts) + 4
+}
+
+func (b *blockBuilder) finish() []byte {
+	var tmp [4]byte
+	for _, r := range b.restarts {
+		binary.LittleEndian.PutUint32(tmp[:], r)
+		b.buf.Write(tmp[:])
+	}
+	binary.LittleEndian.PutUint32(tmp[:], uint32(len(b.restarts)))
+	b.buf.Write(tmp[:])
+	return b.buf.Bytes()
+}
+
+// Writer builds a table file from key-value pairs added in increasing
+// bytewise key order
+type Writer struct {
+	w         io.Writer
+	offset    uint64
+	blockSize int
+	data      blockBuilder
+	index     blockBuilder
+	lastKey   []byte
+	hasKey    bool
+	ikey      []byte
+	count     int64
+	closed    bool
+}
+
+// NewWriter creates a table writer using the default block size
+func NewWriter(w io.Writer) *Writer {
+	return NewWriterSize(w, DefaultBlockSize)
+}
+
+// NewWriterSize creates a table writer with a custom data block size
+func NewWriterSize(w io.Writer, blockSize int) *Writer {
+	tw := &Writer{w: w, blockSize: blockSize}
+	tw.data.reset()
+	tw.index.reset()
+	return tw
+}
+
+// Add appends a key-value pair to the table
+func (tw *Writer) Add(key, value []byte) error {
+	if tw.closed {
+		return ErrClosed
+	}
+
+	if tw.hasKey && bytes.Compare(tw.lastKey, key) >= 0 {
+		return ErrUnsortedKeys
+	}
+
+	tw.ikey = internalKey(tw.ikey[:0], key)
+	tw.data.add(tw.ikey, value)
+	tw.lastKey = append(tw.lastKey[:0], key...)
+	tw.hasKey = true
+	tw.count++
+
+	if tw.data.estimatedSize() >= tw.blockSize {
+		return tw.flushDataBlock()
+	}
+
+	return nil
+}
+
+// Count returns the number of key-value pairs added
+func (tw *Writer) Count() int64 {
+	return tw.count
+}
+
+// Size returns the number of bytes written so far
+func (tw *Writer) Size() int64 {
+	return int64(tw.offset)
+}
+
+func internalKey(dst, key []byte) []byte {
+	var trailer [8]byte
+	// Sequence number 0 and value type
+	binary.LittleEndian.PutUint64(trailer[:], typeValue)
+	dst = append(dst, key...)
+	return append(dst, trailer[:]...)
+}
+
+func (tw *Writer) writeBlock(contents []byte) (blockHandle, error) {
+	h := blockHandle{offset: tw.offset, size: uint64(len(contents))}
+	var trailer [blockTrailerLen]byte
+	trailer[0] = noCompression
+	binary.LittleEndian.PutUint32(trailer[1:], maskedCRC(contents, trailer[:1]))
+
+	if _, err := tw.w.Write(contents); err != nil {
+		return h, err
+	}
+	if _, err := tw.w.Write(trailer[:]); err != nil {
+		return h, err
+	}
+
+	tw.offset += uint64(len(contents) + blockTrailerLen)
+	return h, nil
+}
+
+// flushDataBlock writes the data block and its index entry. The last key of
+// the block is used as the index key, which is >= every key in the block and
+// < every key in the next block.
+func (tw *Writer) flushDataBlock() error {
+	if tw.data.entries == 0 {
+		return nil
+	}
+
+	lastKey := append([]byte(nil), tw.data.lastKey...)
+	h, err := tw.writeBlock(tw.data.finish())
+	if err != nil {
+		return err
+	}
+
+	tw.index.add(lastKey, h.encode(nil))
+	tw.data.reset()
+	return nil
+}
+
+// Close writes the index and footer. It does not close the underlying writer.
+func (tw *Writer) Close() error {
+	if tw.closed {
+		return ErrClosed
+	}
+	tw.closed = true
+
+	if err := tw.flushDataBlock(); err != nil {
+		return err
+	}
+
+	var metaindex blockBuilder
+	metaindex.reset()
+	metaH, err := tw.writeBlock(metaindex.finish())
+	if err != nil {
+		return err
+	}
+
+	indexH, err := tw.writeBlock(tw.index.finish())
+	if err != nil {
+		return err
+	}
+
+	footer := make([]byte, 0, footerLen)
+	footer = metaH.encode(footer)
+	footer = indexH.encode(footer)
+	footer = footer[:footerLen]
+	binary.LittleEndian.PutUint64(footer[footerLen-8:], tableMagic)
+	_, err = tw.w.Write(footer)
+	tw.offset += footerLen
+	return err
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"fmt"
+	"github.com/t3rm1n4l/nitro/skiplist"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bottleneck names the dominant source of write stalls
+type Bottleneck string
+
+const (
+	// BottleneckNone means no source of stalls crossed the threshold
+	BottleneckNone Bottleneck = "none"
+	// BottleneckReadOnly means writes are rejected by a read-only instance
+	BottleneckReadOnly Bottleneck = "read-only"
+	// BottleneckThrottle means writers wait for their WriteLimiter
+	BottleneckThrottle Bottleneck = "throttle"
+	// BottleneckContention means inserts retry due to CAS conflicts
+	BottleneckContention Bottleneck = "cas-contention"
+	// BottleneckGCBacklog means snapshot close waits for the gc workers
+	BottleneckGCBacklog Bottleneck = "gc-backlog"
+)
+
+// A source is reported as the bottleneck once it accounts for this fraction
+// of the interval or of the inserts
+const stallThreshold = 0.1
+
+// WriteStallReport describes where write latency was spent since the
+// previous WriteStallReport call
+type WriteStallReport struct {
+	Interval time.Duration
+
+	Puts            uint64
+	ReadOnlyRejects uint64
+	InsertConflicts uint64
+
+	// Time spent by writers waiting for write limiters and by snapshot
+	// close waiting for the gc queue
+	ThrottleWait time.Duration
+	GCQueueWait  time.Duration
+
+	Bottleneck Bottleneck
+}
+
+func (r WriteStallReport) String() string {
+	return fmt.Sprintf(
+		"interval          = %s\n"+
+			"puts              = %d\n"+
+			"read_only_rejects = %d\n"+
+			"insert_conflicts  = %d\n"+
+			"throttle_wait     = %s\n"+
+			"gc_queue_wait     = %s\n"+
+			"bottleneck        = %s\n",
+		r.Interval, r.Puts, r.ReadOnlyRejects, r.InsertConflicts,
+		r.ThrottleWait, r.GCQueueWait, r.Bottleneck)
+}
+
+// Per writer counters, updated atomically by the owner writer
+type writerStallStats struct {
+	puts       uint64
+	rejects    uint64
+	throttleNs int64
+}
+
+type stallScore struct {
+	b     Bottleneck
+	score float64
+}
+
+type stallState struct {
+	gcWaitNs int64
+
+	sync.Mutex
+	lastTime time.Time
+	last     WriteStallReport
+}
+
+func (w *Writer) throttle(n int64) {
+	t0 := time.Now()
+	w.limiter.wait(n)
+	atomic.AddInt64(&w.stalls.throttleNs, int64(time.Since(t0)))
+}
+
+// sendGCList queues the garbage list of a snapshot and accounts the time
+// spent waiting if the gc workers are behind
+func (m *Nitro) sendGCList(gclist *skiplist.Node) {
+	select {
+	case m.gcchan <- gclist:
+	default:
+		t0 := time.Now()
+		m.gcchan <- gclist
+		atomic.AddInt64(&m.stalls.gcWaitNs, int64(time.Since(t0)))
+	}
+}
+
+// stallTotals returns the cumulative counters since the instance was created
+func (m *Nitro) stallTotals() WriteStallReport {
+	r := WriteStallReport{
+		InsertConflicts: m.aggrStoreStats().InsertConflicts,
+		GCQueueWait:     time.Duration(atomic.LoadInt64(&m.stalls.gcWaitNs)),
+	}
+
+	for w := m.wlist; w != nil; w = w.next {
+		r.Puts += atomic.LoadUint64(&w.stalls.puts)
+		r.ReadOnlyRejects += atomic.LoadUint64(&w.stalls.rejects)
+		r.ThrottleWait += time.Duration(atomic.LoadInt64(&w.stalls.throttleNs))
+	}
+
+	return r
+}
+
+// WriteStallReport returns the write stall counters accumulated since the
+// previous call along with the current bottleneck
+func (m *Nitro) WriteStallReport() WriteStallReport {
+	m.stalls.Lock()
+	defer m.stalls.Unlock()
+
+	now := time.Now()
+	total := m.stallTotals()
+	last := m.stalls.last
+	r := WriteStallReport{
+		Interval:        now.Sub(m.stalls.lastTime),
+		Puts:            total.Puts - last.Puts,
+		ReadOnlyRejects: total.ReadOnlyRejects - last.ReadOnlyRejects,
+		InsertConflicts: total.InsertConflicts - last.InsertConflicts,
+		ThrottleWait:    total.ThrottleWait - last.ThrottleWait,
+		GCQueueWait:     total.GCQueueWait - last.GCQueueWait,
+	}
+	m.stalls.last = total
+	m.stalls.lastTime = now
+
+	r.Bottleneck = r.bottleneck(m.numWriters())
+	return r
+}
+
+func (r *WriteStallReport) bottleneck(writers int) Bottleneck {
+	if r.ReadOnlyRejects > 0 {
+		return BottleneckReadOnly
+	}
+
+	if writers < 1 {
+		writers = 1
+	}
+
+	// Waits of concurrent writers are normalized by the writer count
+	scores := []stallScore{
+		{BottleneckThrottle, float64(r.ThrottleWait) / (float64(r.Interval) * float64(writers))},
+		{BottleneckGCBacklog, float64(r.GCQueueWait) / float64(r.Interval)},
+	}
+
+	if r.Puts > 0 {
+		scores = append(scores, stallScore{BottleneckContention,
+			float64(r.InsertConflicts) / float64(r.Puts)})
+	}
+
+	b, max := BottleneckNone, stallThreshold
+	for _, s := range scores {
+		if s.score >= max {
+			b, max = s.b, s.score
+		}
+	}
+
+	return b
+}
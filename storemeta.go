@@ -0,0 +1,61 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"sync"
+)
+
+// metaState holds the store metadata namespace. The map is never modified
+// in place, so that snapshots can share it without copying.
+type metaState struct {
+	sync.Mutex
+	kv map[string][]byte
+}
+
+func (s *metaState) get() map[string][]byte {
+	s.Lock()
+	defer s.Unlock()
+	return s.kv
+}
+
+// SetMeta stores a value in the store metadata namespace. A nil value
+// removes the key. Metadata is small embedder state such as schema versions
+// or replication cursors. Snapshots created afterwards observe the update
+// and StoreToDisk persists the metadata of the snapshot being stored, so
+// that it is restored by LoadFromDisk along with the matching items.
+func (m *Nitro) SetMeta(key string, value []byte) {
+	m.meta.Lock()
+	defer m.meta.Unlock()
+
+	kv := make(map[string][]byte, len(m.meta.kv)+1)
+	for k, v := range m.meta.kv {
+		kv[k] = v
+	}
+
+	if value == nil {
+		delete(kv, key)
+	} else {
+		kv[key] = append([]byte(nil), value...)
+	}
+
+	m.meta.kv = kv
+}
+
+// GetMeta returns the current value of a metadata key or nil if it is not
+// set. The returned value should not be modified.
+func (m *Nitro) GetMeta(key string) []byte {
+	return m.meta.get()[key]
+}
+
+// GetMeta returns the value of a metadata key as of the snapshot creation
+func (s *Snapshot) GetMeta(key string) []byte {
+	return s.meta[key]
+}
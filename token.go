@@ -0,0 +1,85 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"encoding/binary"
+	nerrors "github.com/t3rm1n4l/nitro/errors"
+)
+
+var (
+	// ErrInvalidToken means the continuation token could not be decoded
+	ErrInvalidToken = nerrors.New(nerrors.ErrInvalidConfig, "Invalid continuation token")
+
+	// ErrNoSnapshot means there is no live snapshot to resume a scan against
+	ErrNoSnapshot = nerrors.New(nerrors.ErrClosed, "No live snapshot available")
+)
+
+/*
+* Continuation token format:
+*   [snapshot sn (4 bytes)][multiset seq (8 bytes)][item data]
+* */
+const tokenHeaderSize = 12
+
+// Token returns an opaque continuation token for the current iterator
+// position. The token can be passed to ResumeIterator() to continue the scan
+// from the current item. A nil token is returned once the scan is complete.
+func (it *Iterator) Token() []byte {
+	if !it.iter.Valid() {
+		return nil
+	}
+
+	itm := (*Item)(it.iter.Get())
+	tok := make([]byte, tokenHeaderSize, tokenHeaderSize+int(itm.dataLen))
+	binary.BigEndian.PutUint32(tok[0:4], it.snap.sn)
+	if it.snap.db.multiset {
+		binary.BigEndian.PutUint64(tok[4:12], it.snap.db.itemSeq(itm))
+	}
+
+	return append(tok, itm.Bytes()...)
+}
+
+// ResumeIterator creates an iterator positioned at the continuation token.
+// The scan continues against the snapshot it was started on if it is still
+// alive or the closest live snapshot otherwise. Since another snapshot
+// may include different mutations, the resumed scan is not guaranteed to be
+// consistent with the pages returned earlier in that case.
+func (m *Nitro) ResumeIterator(token []byte) (*Iterator, error) {
+	if len(token) < tokenHeaderSize {
+		return nil, ErrInvalidToken
+	}
+
+	sn := binary.BigEndian.Uint32(token[0:4])
+	var it *Iterator
+	var newest *Snapshot
+	for _, snap := range m.GetSnapshots() {
+		if snap.sn >= sn {
+			if it = m.NewIterator(snap); it != nil {
+				break
+			}
+		}
+		newest = snap
+	}
+
+	if it == nil && newest != nil {
+		it = m.NewIterator(newest)
+	}
+
+	if it == nil {
+		return nil, ErrNoSnapshot
+	}
+
+	itm := m.newItem(token[tokenHeaderSize:], false)
+	if m.multiset {
+		copy(m.itemSeqBytes(itm), token[4:12])
+	}
+	it.seekItem(itm)
+	return it, nil
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2016 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package nitro
+
+import (
+	"github.com/t3rm1n4l/nitro/skiplist"
+	"time"
+	"unsafe"
+)
+
+// SetUndeleteWindow retains deleted items for at least the given duration,
+// so that they can be restored using Writer.Undelete(). The garbage of a
+// closed snapshot is collected by the first GC() after the window elapses.
+// Items deleted before the first snapshot taken after their insertion are
+// removed immediately and can not be undeleted.
+func (cfg *Config) SetUndeleteWindow(d time.Duration) {
+	cfg.undeleteWindow = d
+}
+
+// retained returns true if the garbage of a closed snapshot is within the
+// undelete window
+func (m *Nitro) retained(snap *Snapshot) bool {
+	return m.undeleteWindow > 0 && time.Since(snap.created) < m.undeleteWindow
+}
+
+// deletedAt returns an upper bound of the time when an item with the
+// deadSn was deleted, which is the creation time of snapshot sn
+func (m *Nitro) deletedAt(sn uint32) (time.Time, bool) {
+	if sn == m.getCurrSn() {
+		return time.Now(), true
+	}
+
+	key := unsafe.Pointer(&Snapshot{sn: sn})
+	for _, list := range []*skiplist.Skiplist{m.snapshots, m.gcsnapshots} {
+		buf := list.MakeBuf()
+		iter := list.NewIterator(CompareSnapshot, buf)
+		var snap *Snapshot
+		if iter.Seek(key) {
+			snap = (*Snapshot)(iter.Get())
+		}
+		iter.Close()
+		list.FreeBuf(buf)
+
+		if snap != nil && snap.baseSn == 0 {
+			return snap.created, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Undelete restores the most recently deleted version of the item with the
+// key of bs, if it was deleted within the undelete window. The item is
+// inserted again along with its metadata and becomes visible to the new
+// snapshots. It returns the skiplist node of the restored item, or nil if
+// the key is live, no deleted version is retained or the instance is
+// read-only. Undelete is not supported in multiset mode.
+func (w *Writer) Undelete(bs []byte) *skiplist.Node {
+	if w.undeleteWindow <= 0 || w.multiset {
+		return nil
+	}
+
+	var data, meta []byte
+	var deadSn uint32
+
+	iter := w.store.NewIterator(w.iterCmp, w.buf)
+	x := w.newItem(bs, false)
+	for iter.Seek(unsafe.Pointer(x)); iter.Valid(); iter.Next() {
+		itm := (*Item)(iter.Get())
+		if w.keyCmp(itm.Bytes(), bs) != 0 {
+			break
+		}
+
+		sn := itm.deadSn
+		if sn == 0 {
+			iter.Close()
+			return nil
+		}
+
+		// Versions of a key are ordered by bornSn
+		deadSn = sn
+		data = append([]byte(nil), itm.Bytes()...)
+		meta = append([]byte(nil), w.ItemMeta(itm)...)
+	}
+	iter.Close()
+
+	if data == nil {
+		return nil
+	}
+
+	if t, ok := w.deletedAt(deadSn); !ok || time.Since(t) > w.undeleteWindow {
+		return nil
+	}
+
+	return w.put(data, meta)
+}